@@ -0,0 +1,87 @@
+package main
+
+import (
+	"io/ioutil"
+	"net/rpc"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"uk.ac.bris.cs/gameoflife/gol"
+)
+
+type Echo struct{}
+
+func (Echo) Echo(msg string, reply *string) error {
+	*reply = msg
+	return nil
+}
+
+// TestMutualTLS checks that certificates from certgen let a client make rpcs over mutual TLS with a token,
+// and that clients without the certificate or the token are turned away
+func TestMutualTLS(t *testing.T) {
+	dir, err := ioutil.TempDir("", "certgen")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	ca, caKey, err := loadOrCreateCA(dir, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"server", "client"} {
+		if err := createCert(dir, name, []string{"127.0.0.1"}, time.Hour, ca, caKey); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// the CA is reused rather than replaced
+	if again, _, err := loadOrCreateCA(dir, time.Hour); err != nil || !again.Equal(ca) {
+		t.Fatalf("CA wasn't reused: %v", err)
+	}
+
+	security := func(name, token string) gol.Security {
+		return gol.Security{
+			CertFile: filepath.Join(dir, name+".pem"),
+			KeyFile:  filepath.Join(dir, name+"-key.pem"),
+			CAFile:   filepath.Join(dir, "ca.pem"),
+			Token:    token,
+		}
+	}
+	server := security("server", "secret")
+	listener, err := server.Listen("127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	rpcServer := rpc.NewServer()
+	rpcServer.Register(Echo{})
+	go server.Accept(listener, rpcServer)
+	address := listener.Addr().String()
+
+	client, err := security("client", "secret").Dial(address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var reply string
+	if err := client.Call("Echo.Echo", "hello", &reply); err != nil || reply != "hello" {
+		t.Errorf("got %q, %v from echo", reply, err)
+	}
+	client.Close()
+
+	rejected := map[string]gol.Security{
+		"wrong token":    security("client", "wrong"),
+		"no certificate": {CAFile: filepath.Join(dir, "ca.pem"), Token: "secret"},
+		"no TLS":         {Token: "secret"},
+	}
+	for name, s := range rejected {
+		client, err := s.Dial(address)
+		if err == nil {
+			err = client.Call("Echo.Echo", "hello", &reply)
+			client.Close()
+		}
+		if err == nil {
+			t.Errorf("client with %s was accepted", name)
+		}
+	}
+}
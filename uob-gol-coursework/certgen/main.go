@@ -0,0 +1,161 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Generates a CA and certificates signed by it, so that the controller, logic engine and nodes can use mutual TLS.
+// The CA is created in the output directory the first time, after that it is reused to sign more certificates:
+//
+//	go run ./certgen -out certs -name engine -hosts 10.0.0.1,localhost
+//	go run ./certgen -out certs -name node1 -hosts 10.0.0.2
+//	go run ./certgen -out certs -name controller
+//
+// Each certificate can be used both as a server and a client, as the logic engine and nodes are both
+func main() {
+	out := flag.String("out", "certs", "directory to write the CA and certificates to")
+	name := flag.String("name", "", "name of the certificate to create, written to <name>.pem and <name>-key.pem. Only the CA is created if empty")
+	hosts := flag.String("hosts", "127.0.0.1,localhost", "comma separated IPs and host names the certificate is for")
+	days := flag.Int("days", 365, "number of days the certificates are valid for")
+	flag.Parse()
+
+	if err := os.MkdirAll(*out, 0700); err != nil {
+		fmt.Println("Error creating output directory:", err)
+		os.Exit(1)
+	}
+	validFor := time.Duration(*days) * 24 * time.Hour
+	ca, caKey, err := loadOrCreateCA(*out, validFor)
+	if err != nil {
+		fmt.Println("Error creating CA:", err)
+		os.Exit(1)
+	}
+	if *name == "" {
+		return
+	}
+	if err := createCert(*out, *name, strings.Split(*hosts, ","), validFor, ca, caKey); err != nil {
+		fmt.Println("Error creating certificate:", err)
+		os.Exit(1)
+	}
+	fmt.Println("Created", filepath.Join(*out, *name+".pem"))
+}
+
+// reads the CA from the directory, creating it if it isn't there
+func loadOrCreateCA(dir string, validFor time.Duration) (*x509.Certificate, *ecdsa.PrivateKey, error) {
+	certFile := filepath.Join(dir, "ca.pem")
+	keyFile := filepath.Join(dir, "ca-key.pem")
+	if _, err := os.Stat(certFile); err == nil {
+		return loadCA(certFile, keyFile)
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, nil, err
+	}
+	template, err := newTemplate("gameoflife CA", validFor)
+	if err != nil {
+		return nil, nil, err
+	}
+	template.IsCA = true
+	template.BasicConstraintsValid = true
+	template.KeyUsage = x509.KeyUsageCertSign | x509.KeyUsageCRLSign
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := writeKeyPair(certFile, keyFile, der, key); err != nil {
+		return nil, nil, err
+	}
+	fmt.Println("Created", certFile)
+	return loadCA(certFile, keyFile)
+}
+
+func loadCA(certFile, keyFile string) (*x509.Certificate, *ecdsa.PrivateKey, error) {
+	certPEM, err := ioutil.ReadFile(certFile)
+	if err != nil {
+		return nil, nil, err
+	}
+	keyPEM, err := ioutil.ReadFile(keyFile)
+	if err != nil {
+		return nil, nil, err
+	}
+	certBlock, _ := pem.Decode(certPEM)
+	keyBlock, _ := pem.Decode(keyPEM)
+	if certBlock == nil || keyBlock == nil {
+		return nil, nil, errors.New("CA files aren't PEM")
+	}
+	cert, err := x509.ParseCertificate(certBlock.Bytes)
+	if err != nil {
+		return nil, nil, err
+	}
+	key, err := x509.ParseECPrivateKey(keyBlock.Bytes)
+	return cert, key, err
+}
+
+// creates a certificate signed by the CA for the hosts
+func createCert(dir, name string, hosts []string, validFor time.Duration, ca *x509.Certificate, caKey *ecdsa.PrivateKey) error {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return err
+	}
+	template, err := newTemplate(name, validFor)
+	if err != nil {
+		return err
+	}
+	template.KeyUsage = x509.KeyUsageDigitalSignature
+	template.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth}
+	for _, host := range hosts {
+		host = strings.TrimSpace(host)
+		if ip := net.ParseIP(host); ip != nil {
+			template.IPAddresses = append(template.IPAddresses, ip)
+		} else if host != "" {
+			template.DNSNames = append(template.DNSNames, host)
+		}
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, ca, &key.PublicKey, caKey)
+	if err != nil {
+		return err
+	}
+	return writeKeyPair(filepath.Join(dir, name+".pem"), filepath.Join(dir, name+"-key.pem"), der, key)
+}
+
+func newTemplate(name string, validFor time.Duration) (*x509.Certificate, error) {
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, err
+	}
+	return &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(validFor),
+	}, nil
+}
+
+// writes the certificate and key as PEM, with only the owner able to read the key
+func writeKeyPair(certFile, keyFile string, der []byte, key *ecdsa.PrivateKey) error {
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return err
+	}
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+	if err := ioutil.WriteFile(certFile, certPEM, 0644); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(keyFile, keyPEM, 0600)
+}
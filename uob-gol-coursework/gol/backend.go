@@ -0,0 +1,42 @@
+package gol
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Backend is the algorithm used to calculate turns
+type Backend int
+
+const (
+	Strips   Backend = iota // the world is split into strips of rows, each calculated cell by cell on its own thread or node
+	Hashlife                // a memoised quadtree in this process, which can jump 2^k turns at once
+)
+
+// ParseBackend turns the name of a backend into a Backend
+func ParseBackend(name string) (Backend, error) {
+	switch strings.ToLower(name) {
+	case "", "strips":
+		return Strips, nil
+	case "hashlife":
+		return Hashlife, nil
+	}
+	return Strips, fmt.Errorf("unknown backend %q, expected strips or hashlife", name)
+}
+
+func (b Backend) String() string {
+	switch b {
+	case Strips:
+		return "strips"
+	case Hashlife:
+		return "hashlife"
+	default:
+		return "Incorrect Backend"
+	}
+}
+
+// Set allows a Backend to be used as a flag
+func (b *Backend) Set(name string) (err error) {
+	*b, err = ParseBackend(name)
+	return
+}
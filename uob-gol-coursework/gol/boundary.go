@@ -0,0 +1,111 @@
+package gol
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Boundary is the topology of the edges of the world
+type Boundary int
+
+const (
+	Torus       Boundary = iota // every edge wraps around to the opposite edge
+	DeadEdges                   // cells beyond the edges are always dead
+	Reflect                     // cells beyond the edges mirror the cells just inside them
+	KleinBottle                 // left and right wrap, top and bottom wrap with the world flipped left to right
+	Unbounded                   // there are no edges, the world is an infinite plane that only the local engine can calculate
+)
+
+// Edge describes where the halo rows on one side of a strip come from
+type Edge int
+
+const (
+	NeighbourEdge Edge = iota // rows from the neighbouring node
+	FlippedEdge               // rows from the neighbouring node, flipped left to right
+	DeadEdge                  // dead rows, nothing is exchanged
+	ReflectedEdge             // the strip's own rows reflected back onto itself, nothing is exchanged
+)
+
+// Exchanged returns whether the halo rows for the edge come from another node
+func (e Edge) Exchanged() bool {
+	return e == NeighbourEdge || e == FlippedEdge
+}
+
+// ParseBoundary turns the name of a boundary into a Boundary
+func ParseBoundary(name string) (Boundary, error) {
+	switch strings.ToLower(name) {
+	case "", "torus", "wrap":
+		return Torus, nil
+	case "dead":
+		return DeadEdges, nil
+	case "reflect", "reflecting":
+		return Reflect, nil
+	case "klein":
+		return KleinBottle, nil
+	case "unbounded", "plane":
+		return Unbounded, nil
+	}
+	return Torus, fmt.Errorf("unknown boundary %q, expected torus, dead, reflect, klein or unbounded", name)
+}
+
+func (b Boundary) String() string {
+	switch b {
+	case Torus:
+		return "torus"
+	case DeadEdges:
+		return "dead"
+	case Reflect:
+		return "reflect"
+	case KleinBottle:
+		return "klein"
+	case Unbounded:
+		return "unbounded"
+	default:
+		return "Incorrect Boundary"
+	}
+}
+
+// Set allows a Boundary to be used as a flag
+func (b *Boundary) Set(name string) (err error) {
+	*b, err = ParseBoundary(name)
+	return
+}
+
+// VerticalEdge returns how the halo above the top row and below the bottom row of the world is made
+func (b Boundary) VerticalEdge() Edge {
+	switch b {
+	case DeadEdges:
+		return DeadEdge
+	case Reflect:
+		return ReflectedEdge
+	case KleinBottle:
+		return FlippedEdge
+	default:
+		return NeighbourEdge
+	}
+}
+
+// Cell returns the cell inside the world that the cell at x, y refers to, x and y may be at most one
+// cell beyond the edges. If the cell is always dead then ok is false
+func (b Boundary) Cell(x, y, width, height int) (cx, cy int, ok bool) {
+	switch b {
+	case DeadEdges:
+		return x, y, x >= 0 && x < width && y >= 0 && y < height
+	case Reflect:
+		return clamp(x, width), clamp(y, height), true
+	case KleinBottle:
+		if y < 0 || y >= height {
+			x = width - 1 - x
+		}
+	}
+	return Mod(x, width), Mod(y, height), true
+}
+
+func clamp(x, m int) int {
+	if x < 0 {
+		return 0
+	} else if x >= m {
+		return m - 1
+	}
+	return x
+}
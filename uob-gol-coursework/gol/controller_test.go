@@ -0,0 +1,275 @@
+package gol
+
+import (
+	"image"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeEngine is an engine held in memory that records the calls made on it
+type fakeEngine struct {
+	lock   sync.Mutex
+	calls  []string
+	paused bool
+	world  Worldcells
+}
+
+func (f *fakeEngine) record(call string) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	f.calls = append(f.calls, call)
+}
+
+func (f *fakeEngine) Evolve(p Params, world PackedWorld) (bool, error) {
+	f.record("Evolve")
+	f.world = Worldcells{World: world, Turn: 0}
+	return false, nil
+}
+
+func (f *fakeEngine) Pause() (int, error) {
+	f.record("Pause")
+	f.paused = true
+	return f.world.Turn, nil
+}
+
+func (f *fakeEngine) Resume() error {
+	f.record("Resume")
+	f.paused = false
+	return nil
+}
+
+func (f *fakeEngine) Snapshot() (Worldcells, error) {
+	f.record("Snapshot")
+	return f.world, nil
+}
+
+func (f *fakeEngine) Flips(since int) (Flips, error) {
+	f.record("Flips")
+	return Diff(NewPackedWorld(f.world.World.Width, f.world.World.Height), f.world.World), nil
+}
+
+func (f *fakeEngine) Recorded(after int) ([]Worldcells, error) {
+	f.record("Recorded")
+	return nil, nil
+}
+
+func (f *fakeEngine) Stats() (SessionInfo, error) {
+	f.record("Stats")
+	return SessionInfo{Turn: f.world.Turn, AliveCells: f.world.World.Count(), Paused: f.paused, Running: true}, nil
+}
+
+func (f *fakeEngine) Subscribe(after int, timeout time.Duration) ([]Update, error) {
+	time.Sleep(timeout)
+	return nil, nil
+}
+
+func (f *fakeEngine) Shutdown() error {
+	f.record("Shutdown")
+	return nil
+}
+
+func (f *fakeEngine) Close() error {
+	f.record("Close")
+	return nil
+}
+
+// pressKeys runs handleKeypresses on a controller using the fake engine until it handles a q, returning
+// the controller and the channels the IO would read from
+func pressKeys(t *testing.T, engine *fakeEngine, keys string) (*Controller, chan ioCommand, chan string, chan []byte) {
+	keyPresses := make(chan rune, len(keys)+1)
+	for _, key := range keys {
+		keyPresses <- key
+	}
+	keyPresses <- 'q'
+
+	width, height := engine.world.World.Width, engine.world.World.Height
+	ioCommands := make(chan ioCommand, len(keys))
+	filenames := make(chan string, len(keys))
+	output := make(chan []byte, len(keys)*height)
+	con := createController(Params{ImageWidth: width, ImageHeight: height}, distributorChannels{
+		events:     make(chan Event, 100),
+		ioCommand:  ioCommands,
+		filepath:   filenames,
+		bounds:     make(chan image.Rectangle, len(keys)),
+		output:     output,
+		keyPresses: keyPresses,
+	}, engine)
+
+	displayDone := make(chan bool, 1)
+	updatesDone := make(chan bool, 1)
+	finished := make(chan bool)
+	go func() {
+		con.handleKeypresses(make(chan bool), displayDone, updatesDone)
+		close(finished)
+	}()
+	select {
+	case <-finished:
+	case <-time.After(5 * time.Second):
+		t.Fatal("handleKeypresses didn't return after q")
+	}
+	if len(displayDone) != 1 || len(updatesDone) != 1 {
+		t.Error("q didn't stop the display and updates goroutines")
+	}
+	return con, ioCommands, filenames, output
+}
+
+// TestPauseKey checks that p pauses and resumes the engine, following any pause made by another controller
+func TestPauseKey(t *testing.T) {
+	world := NewPackedWorld(4, 4)
+	engine := &fakeEngine{world: Worldcells{World: world, Turn: 5}}
+	con, _, _, _ := pressKeys(t, engine, "pp")
+	expected := []string{"Stats", "Pause", "Stats", "Resume"}
+	if !reflect.DeepEqual(engine.calls, expected) {
+		t.Errorf("got calls %v, expected %v", engine.calls, expected)
+	}
+	if con.paused || engine.paused {
+		t.Error("engine still paused after pressing p twice")
+	}
+
+	// another controller has paused the session, so the first p resumes it
+	engine = &fakeEngine{world: Worldcells{World: world, Turn: 5}, paused: true}
+	con, _, _, _ = pressKeys(t, engine, "p")
+	expected = []string{"Stats", "Resume"}
+	if !reflect.DeepEqual(engine.calls, expected) {
+		t.Errorf("got calls %v, expected %v", engine.calls, expected)
+	}
+	if con.paused || engine.paused {
+		t.Error("engine still paused after pressing p")
+	}
+}
+
+// TestSaveKey checks that s writes out the engine's latest world, named after its size and turn
+func TestSaveKey(t *testing.T) {
+	world := NewPackedWorld(3, 2)
+	world.Set(1, 0, true)
+	world.Set(2, 1, true)
+	engine := &fakeEngine{world: Worldcells{World: world, Turn: 7}}
+	_, ioCommands, filenames, output := pressKeys(t, engine, "s")
+
+	if len(ioCommands) != 1 || <-ioCommands != ioOutput {
+		t.Fatal("s didn't send an output command to the IO")
+	}
+	if filename := <-filenames; filename != "3x2x7" {
+		t.Errorf("wrote %s, expected 3x2x7", filename)
+	}
+	written := make([][]byte, 0, 2)
+	for len(output) > 0 {
+		written = append(written, <-output)
+	}
+	expected := [][]byte{{0, 255, 0}, {0, 0, 255}}
+	if !reflect.DeepEqual(written, expected) {
+		t.Errorf("wrote cells %v, expected %v", written, expected)
+	}
+}
+
+// TestKillKey checks that k saves the latest world and shuts the engine down, and that the controller
+// waits for the io to finish writing every save, including one made by s just before, before it quits
+func TestKillKey(t *testing.T) {
+	for _, keys := range []string{"k", "sk"} {
+		commands, written, last, calls := runKeys(t, keys)
+		expected := []ioCommand{ioInput}
+		for range keys {
+			expected = append(expected, ioOutput)
+		}
+		expected = append(expected, ioCheckIdle)
+		if !reflect.DeepEqual(commands, expected) {
+			t.Errorf("%s: io was given commands %v, expected %v", keys, commands, expected)
+		}
+		if len(written) != len(keys) || written[len(written)-1] != "3x2x0" {
+			t.Errorf("%s: wrote %v, expected the world to be saved as 3x2x0 %d times", keys, written, len(keys))
+		}
+		if change, ok := last.(StateChange); !ok || change.NewState != Quitting {
+			t.Errorf("%s: last event was %v, expected the controller to be quitting", keys, last)
+		}
+		shutdown := false
+		for _, call := range calls {
+			shutdown = shutdown || call == "Shutdown"
+		}
+		if !shutdown {
+			t.Errorf("%s: got calls %v, expected the engine to be shut down", keys, calls)
+		}
+	}
+}
+
+// runKeys runs a controller with the keys pressed until it quits, returning the commands given to a fake io,
+// the files it was told to write, the last event and the calls made on the engine
+func runKeys(t *testing.T, keys string) ([]ioCommand, []string, Event, []string) {
+	world := NewPackedWorld(3, 2)
+	world.Set(0, 1, true)
+	engine := &fakeEngine{}
+	keyPresses := make(chan rune, len(keys))
+	for _, key := range keys {
+		keyPresses <- key
+	}
+	ioCommands := make(chan ioCommand)
+	ioIdle := make(chan bool)
+	input := make(chan []byte)
+	output := make(chan []byte)
+	filenames := make(chan string)
+	bounds := make(chan image.Rectangle)
+	events := make(chan Event)
+	con := createController(Params{Turns: 100, ImageWidth: 3, ImageHeight: 2}, distributorChannels{
+		events:     events,
+		ioCommand:  ioCommands,
+		ioIdle:     ioIdle,
+		input:      input,
+		output:     output,
+		filepath:   filenames,
+		bounds:     bounds,
+		keyPresses: keyPresses,
+	}, engine)
+
+	// a fake io that records the commands it is given
+	var commands []ioCommand
+	var written []string
+	go func() {
+		for command := range ioCommands {
+			commands = append(commands, command)
+			switch command {
+			case ioInput:
+				for _, row := range world.Unpack() {
+					input <- row
+				}
+			case ioOutput:
+				written = append(written, <-filenames)
+				<-bounds
+				for y := 0; y < 2; y++ {
+					<-output
+				}
+			case ioCheckIdle:
+				ioIdle <- true
+			}
+		}
+	}()
+
+	finished := make(chan bool)
+	go func() {
+		con.run()
+		close(finished)
+	}()
+	var last Event
+	timeout := time.After(5 * time.Second)
+	for done := false; !done; {
+		select {
+		case event, ok := <-events:
+			if !ok {
+				done = true
+				break
+			}
+			if _, final := event.(FinalTurnComplete); final {
+				t.Errorf("%s: the controller carried on to the final turn after k", keys)
+			}
+			last = event
+		case <-timeout:
+			t.Fatalf("%s: the controller didn't quit after k", keys)
+		}
+	}
+	<-finished
+	close(ioCommands)
+
+	engine.lock.Lock()
+	defer engine.lock.Unlock()
+	return commands, written, last, append([]string{}, engine.calls...)
+}
@@ -0,0 +1,136 @@
+package gol
+
+import (
+	"fmt"
+	"net/rpc"
+	"time"
+)
+
+// Engine runs one session of the Game of Life for the controller. It is either the logic engine,
+// reached over the network, or the local engine running in this process
+type Engine interface {
+	// Evolve starts the session with the params and world. If the session is already running then
+	// it is left as it is and attached is true
+	Evolve(p Params, world PackedWorld) (attached bool, err error)
+	// Pause stops the session after the current turn, returning the turn it stopped on
+	Pause() (turn int, err error)
+	Resume() error
+	// Snapshot returns the world as of the latest turn
+	Snapshot() (Worldcells, error)
+	// Flips returns the cells flipped since the turn the display is showing, -1 if it isn't showing anything yet
+	Flips(since int) (Flips, error)
+	// Recorded returns the frames recorded after the turn, forgetting the earlier ones
+	Recorded(after int) ([]Worldcells, error)
+	// Stats returns the session's params, turn, number of alive cells and whether it is paused
+	Stats() (SessionInfo, error)
+	// Subscribe waits for updates after seq, returning none if the timeout passes first
+	Subscribe(after int, timeout time.Duration) ([]Update, error)
+	// Shutdown stops the session, along with the logic engine and its nodes if there are any
+	Shutdown() error
+	// Close disconnects the controller from the engine
+	Close() error
+}
+
+// DialEngine connects to the logic engine the params say to use, or starts a local engine if there isn't one
+// or the params ask for hashlife or an unbounded world
+func DialEngine(p Params) (Engine, error) {
+	address := ServerAddress(p)
+	if p.Backend == Hashlife {
+		fmt.Println("running hashlife locally")
+		return newLocalEngine(p.Threads), nil
+	}
+	if p.Boundary == Unbounded {
+		fmt.Println("running the unbounded world locally")
+		return newLocalEngine(p.Threads), nil
+	}
+	if address == "" {
+		fmt.Println("running locally with", p.Threads, "threads")
+		return newLocalEngine(p.Threads), nil
+	}
+	client, err := p.Security.Dial(address)
+	if err != nil {
+		return nil, err
+	}
+	fmt.Println("connected to logic engine")
+	return &remoteEngine{client, p.Session}, nil
+}
+
+// The logic engine, with every call made on the controller's session
+type remoteEngine struct {
+	client  *rpc.Client
+	session string
+}
+
+func (r *remoteEngine) Evolve(p Params, world PackedWorld) (attached bool, err error) {
+	var msg string
+	// the logic engine keeps the params, so they shouldn't include the token or where the keys are
+	p.Security = Security{}
+	err = r.client.Call("Game.Evolve", Args{p, world}, &msg)
+	return msg == "already running", err
+}
+
+func (r *remoteEngine) Pause() (turn int, err error) {
+	err = r.client.Call("Game.Pause", r.session, &turn)
+	return
+}
+
+func (r *remoteEngine) Resume() error {
+	return r.client.Call("Game.Resume", r.session, nil)
+}
+
+func (r *remoteEngine) Snapshot() (wc Worldcells, err error) {
+	err = r.client.Call("Game.GetWorld", r.session, &wc)
+	return
+}
+
+func (r *remoteEngine) Flips(since int) (flips Flips, err error) {
+	err = r.client.Call("Game.GetFlips", FlipsArgs{r.session, since}, &flips)
+	return
+}
+
+func (r *remoteEngine) Recorded(after int) (frames []Worldcells, err error) {
+	err = r.client.Call("Game.GetRecorded", RecordedArgs{r.session, after}, &frames)
+	return
+}
+
+func (r *remoteEngine) Stats() (info SessionInfo, err error) {
+	err = r.client.Call("Game.Attach", r.session, &info)
+	return
+}
+
+func (r *remoteEngine) Subscribe(after int, timeout time.Duration) (updates []Update, err error) {
+	err = r.client.Call("Game.Updates", UpdatesArgs{r.session, after, timeout}, &updates)
+	return
+}
+
+func (r *remoteEngine) Shutdown() error {
+	return r.client.Call("Game.Shutdown", "", nil)
+}
+
+func (r *remoteEngine) Close() error {
+	return r.client.Close()
+}
+
+// ListSessions returns the sessions running on the logic engine the params say to use
+func ListSessions(p Params) ([]SessionInfo, error) {
+	client, err := p.Security.Dial(ServerAddress(p))
+	if err != nil {
+		return nil, err
+	}
+	defer client.Close()
+	var sessions []SessionInfo
+	err = client.Call("Game.ListSessions", "", &sessions)
+	return sessions, err
+}
+
+// ListWorkers returns the nodes of the logic engine the params say to use
+func ListWorkers(p Params) (Membership, error) {
+	var membership Membership
+	client, err := p.Security.Dial(ServerAddress(p))
+	if err != nil {
+		return membership, err
+	}
+	defer client.Close()
+	err = client.Call("Game.ListWorkers", "", &membership)
+	return membership, err
+}
@@ -68,6 +68,35 @@ type FinalTurnComplete struct {
 	Alive          []util.Cell
 }
 
+// MembershipChange is an Event notifying the user that a node has joined or left the logic engine.
+// This Event is sent every time the logic engine's membership epoch changes.
+type MembershipChange struct { // implements Event
+	CompletedTurns int
+	Epoch          int
+	Address        string
+	State          WorkerState
+	Workers        int
+}
+
+// Severity says whether a problem stopped what was being done or the game carried on regardless.
+type Severity int
+
+const (
+	Error Severity = iota
+	Warning
+)
+
+// ErrorReport is an Event notifying the user that something went wrong.
+// Component is the part of the program that failed (io, controller or engine), Operation what it was doing
+// and Cause the error it got. The game keeps going after warnings, and after errors that only stop a save.
+type ErrorReport struct { // implements Event
+	CompletedTurns int
+	Severity       Severity
+	Component      string
+	Operation      string
+	Cause          error
+}
+
 // String methods allow the different types of Events and States to be printed.
 
 func (state State) String() string {
@@ -131,6 +160,33 @@ func (event FinalTurnComplete) GetCompletedTurns() int {
 	return event.CompletedTurns
 }
 
+func (severity Severity) String() string {
+	switch severity {
+	case Error:
+		return "Error"
+	case Warning:
+		return "Warning"
+	default:
+		return "Incorrect Severity"
+	}
+}
+
+func (event ErrorReport) String() string {
+	return fmt.Sprintf("%v in %v %v: %v", event.Severity, event.Component, event.Operation, event.Cause)
+}
+
+func (event ErrorReport) GetCompletedTurns() int {
+	return event.CompletedTurns
+}
+
+func (event MembershipChange) String() string {
+	return fmt.Sprintf("Node %v %v, %v nodes (epoch %v)", event.Address, event.State, event.Workers, event.Epoch)
+}
+
+func (event MembershipChange) GetCompletedTurns() int {
+	return event.CompletedTurns
+}
+
 // This might all seem like weird syntax to you...
 // You have however seen something similar to it before in first year.
 
@@ -0,0 +1,189 @@
+package gol
+
+import (
+	"math/bits"
+
+	"uk.ac.bris.cs/gameoflife/util"
+)
+
+// Flips is the set of cells that changed between two turns of a world. Reading the world a row at a time,
+// Runs alternates between the number of cells that stayed the same and the number that flipped, so a
+// world where little changes takes up very little space
+type Flips struct {
+	From   int // the turn the cells are flipped from, -1 if they are flipped from an empty world
+	Turn   int // the turn the cells are flipped to
+	Width  int
+	Height int
+	Runs   []int
+}
+
+// Struct used by the controller to ask the logic engine for the cells flipped since the last frame it displayed
+type FlipsArgs struct {
+	Session string
+	Since   int // the turn the controller is displaying, -1 if it isn't displaying anything yet
+}
+
+// Diff returns the cells that are different in the two worlds, which must be the same size
+func Diff(from, to PackedWorld) Flips {
+	flips := Flips{Width: to.Width, Height: to.Height, Runs: []int{}}
+	stride := rowBytes(to.Width)
+	end := 0 // the cell after the last flipped cell
+	for y := 0; y < to.Height; y++ {
+		for i := 0; i < stride; i++ {
+			b := from.Bits[y*stride+i] ^ to.Bits[y*stride+i]
+			// skip over unchanged bytes rather than checking each bit
+			for b != 0 {
+				bit := bits.TrailingZeros8(b)
+				cell := y*to.Width + i*8 + bit
+				if cell == end && len(flips.Runs) > 0 {
+					flips.Runs[len(flips.Runs)-1]++
+				} else {
+					flips.Runs = append(flips.Runs, cell-end, 1)
+				}
+				end = cell + 1
+				b &^= 1 << uint(bit)
+			}
+		}
+	}
+	return flips
+}
+
+// calls f with each flipped cell
+func (f Flips) each(fn func(x, y int)) {
+	cell := 0
+	for i := 0; i+1 < len(f.Runs); i += 2 {
+		cell += f.Runs[i]
+		for end := cell + f.Runs[i+1]; cell < end; cell++ {
+			fn(cell%f.Width, cell/f.Width)
+		}
+	}
+}
+
+// Cells returns every flipped cell
+func (f Flips) Cells() []util.Cell {
+	cells := []util.Cell{}
+	f.each(func(x, y int) {
+		cells = append(cells, util.Cell{X: x, Y: y})
+	})
+	return cells
+}
+
+// Apply flips the cells in the world, turning the world at From into the world at Turn
+func (f Flips) Apply(world PackedWorld) {
+	f.each(func(x, y int) {
+		world.Set(x, y, !world.Alive(x, y))
+	})
+}
+
+// JoinFlips joins the flips of strips of rows back together into the flips of a single world
+func JoinFlips(strips ...Flips) Flips {
+	joined := Flips{Runs: []int{}}
+	offset := 0 // the first cell of the strip in the joined world
+	end := 0    // the cell after the last flipped cell
+	for _, strip := range strips {
+		cell := offset
+		for i := 0; i+1 < len(strip.Runs); i += 2 {
+			cell += strip.Runs[i]
+			if cell == end && len(joined.Runs) > 0 {
+				joined.Runs[len(joined.Runs)-1] += strip.Runs[i+1]
+			} else {
+				joined.Runs = append(joined.Runs, cell-end, strip.Runs[i+1])
+			}
+			cell += strip.Runs[i+1]
+			end = cell
+		}
+		joined.Width = strip.Width
+		joined.Height += strip.Height
+		offset += strip.Width * strip.Height
+	}
+	return joined
+}
+
+// the number of frames kept for working out the cells flipped since a display's last frame
+const keptFrames = 8
+
+// Frames keeps the newest frame sent to displays along with the flips that led up to it from the few frames
+// before, so that the next frame can be sent as the cells that have flipped since a display's last one
+type Frames struct {
+	world  PackedWorld // the newest frame, which isn't shared with anything else as flips are applied to it
+	turn   int
+	deltas []Flips // the flips from each of the last few frames to the one after it, oldest first
+}
+
+// Turn returns the turn of the newest frame, or -1 if there are no frames yet
+func (f *Frames) Turn() int {
+	if f.world.Bits == nil {
+		return -1
+	}
+	return f.turn
+}
+
+// Next makes the world the newest frame, working out the cells flipped since the frame before it. A world
+// from an earlier turn, or of a different size, replaces every frame
+func (f *Frames) Next(world PackedWorld, turn int) {
+	if f.world.Bits != nil && turn == f.turn {
+		return
+	}
+	if f.world.Bits == nil || turn < f.turn || world.Width != f.world.Width || world.Height != f.world.Height {
+		f.world = PackedWorld{world.Width, world.Height, append([]byte{}, world.Bits...)}
+		f.turn = turn
+		f.deltas = nil
+		return
+	}
+	flips := Diff(f.world, world)
+	flips.From = f.turn
+	flips.Turn = turn
+	f.Add(flips)
+}
+
+// Add makes the world the flips lead to the newest frame, the flips must be from the newest frame's turn
+func (f *Frames) Add(flips Flips) {
+	flips.Apply(f.world)
+	f.turn = flips.Turn
+	f.deltas = append(f.deltas, flips)
+	if len(f.deltas) > keptFrames {
+		f.deltas = f.deltas[1:]
+	}
+}
+
+// Since returns the cells flipped between the frame at turn since and the newest frame. If the frame at
+// since is too old then every alive cell is returned, flipped from an empty world
+func (f *Frames) Since(since int) Flips {
+	empty := NewPackedWorld(f.world.Width, f.world.Height)
+	var flips Flips
+	switch first := f.find(since); {
+	case since == f.turn:
+		flips = Flips{Width: f.world.Width, Height: f.world.Height, Runs: []int{}}
+	case first >= 0 && first == len(f.deltas)-1:
+		flips = f.deltas[first]
+	case first >= 0:
+		// a cell flipped an even number of times since the display's frame hasn't changed
+		for _, delta := range f.deltas[first:] {
+			delta.Apply(empty)
+		}
+		flips = Diff(NewPackedWorld(f.world.Width, f.world.Height), empty)
+	default:
+		flips = Diff(empty, f.world)
+		since = -1
+	}
+	flips.From = since
+	flips.Turn = f.turn
+	return flips
+}
+
+// returns the index of the flips from the frame at turn since, or -1 if that frame is too old
+func (f *Frames) find(since int) int {
+	for i, delta := range f.deltas {
+		if delta.From == since {
+			return i
+		}
+	}
+	return -1
+}
+
+// Flips makes the world the newest frame and returns the cells flipped since the frame at turn since. If the
+// frame at since is too old then every alive cell is returned, flipped from an empty world
+func (f *Frames) Flips(world PackedWorld, turn, since int) Flips {
+	f.Next(world, turn)
+	return f.Since(since)
+}
@@ -0,0 +1,131 @@
+package gol
+
+import (
+	"fmt"
+	"math/rand"
+	"testing"
+)
+
+// TestDiff checks that applying the flips between two worlds turns the first into the second
+func TestDiff(t *testing.T) {
+	random := rand.New(rand.NewSource(1))
+	for _, size := range [][2]int{{1, 1}, {8, 3}, {13, 7}, {64, 64}} {
+		width, height := size[0], size[1]
+		from := NewPackedWorld(width, height)
+		to := NewPackedWorld(width, height)
+		for y := 0; y < height; y++ {
+			for x := 0; x < width; x++ {
+				from.Set(x, y, random.Intn(2) == 0)
+				to.Set(x, y, random.Intn(2) == 0)
+			}
+		}
+
+		flips := Diff(from, to)
+		changed := 0
+		for y := 0; y < height; y++ {
+			for x := 0; x < width; x++ {
+				if from.Alive(x, y) != to.Alive(x, y) {
+					changed++
+				}
+			}
+		}
+		if len(flips.Cells()) != changed {
+			t.Errorf("%dx%d: got %d flipped cells, expected %d", width, height, len(flips.Cells()), changed)
+		}
+		flips.Apply(from)
+		if string(from.Bits) != string(to.Bits) {
+			t.Errorf("%dx%d: applying the flips doesn't give the second world", width, height)
+		}
+		if len(Diff(to, to).Runs) != 0 {
+			t.Errorf("%dx%d: a world differs from itself", width, height)
+		}
+	}
+}
+
+// TestJoinFlips checks that the flips of strips joined together are the flips of the world the strips were sliced from
+func TestJoinFlips(t *testing.T) {
+	random := rand.New(rand.NewSource(2))
+	for _, width := range []int{1, 8, 13} {
+		const height = 12
+		from := NewPackedWorld(width, height)
+		to := NewPackedWorld(width, height)
+		for y := 0; y < height; y++ {
+			for x := 0; x < width; x++ {
+				from.Set(x, y, random.Intn(2) == 0)
+				to.Set(x, y, random.Intn(2) == 0)
+			}
+		}
+		// flip every cell along a strip boundary, so runs have to be joined across it
+		for x := 0; x < width; x++ {
+			from.Set(x, 4, false)
+			to.Set(x, 4, true)
+			from.Set(x, 5, false)
+			to.Set(x, 5, true)
+		}
+
+		var strips []Flips
+		for _, rows := range [][2]int{{0, 5}, {5, 6}, {6, 6}, {6, 12}} {
+			strips = append(strips, Diff(from.Rows(rows[0], rows[1]), to.Rows(rows[0], rows[1])))
+		}
+		joined := JoinFlips(strips...)
+		expected := Diff(from, to)
+		if joined.Width != width || joined.Height != height || fmt.Sprint(joined.Runs) != fmt.Sprint(expected.Runs) {
+			t.Errorf("width %d: joined flips are %dx%d %v, expected %v", width, joined.Width, joined.Height, joined.Runs, expected.Runs)
+		}
+	}
+}
+
+// TestFrames checks that the flips since any kept frame turn that frame into the newest, whether the
+// frames were added as whole worlds or as flips
+func TestFrames(t *testing.T) {
+	const width, height = 11, 9
+	random := rand.New(rand.NewSource(3))
+	var frames Frames
+	worlds := map[int]PackedWorld{}
+	world := NewPackedWorld(width, height)
+	for turn := 0; turn <= 20; turn += 2 {
+		next := NewPackedWorld(width, height)
+		for y := 0; y < height; y++ {
+			for x := 0; x < width; x++ {
+				next.Set(x, y, random.Intn(4) == 0)
+			}
+		}
+		if turn%4 == 0 {
+			frames.Next(next, turn)
+		} else {
+			flips := Diff(world, next)
+			flips.From, flips.Turn = frames.Turn(), turn
+			frames.Add(flips)
+		}
+		world = next
+		worlds[turn] = next
+	}
+	if frames.Turn() != 20 {
+		t.Fatalf("newest frame is from turn %d, expected 20", frames.Turn())
+	}
+
+	for since := -1; since <= 20; since++ {
+		flips := frames.Since(since)
+		start, kept := worlds[since]
+		// only the last few frames are kept, the display gets the whole world for older ones
+		if !kept || since < 20-2*keptFrames {
+			start = NewPackedWorld(width, height)
+			if flips.From != -1 {
+				t.Errorf("since %d: flips are from turn %d, expected the whole world", since, flips.From)
+			}
+		} else if flips.From != since {
+			t.Errorf("since %d: flips are from turn %d", since, flips.From)
+		}
+		shown := PackedWorld{width, height, append([]byte{}, start.Bits...)}
+		flips.Apply(shown)
+		if flips.Turn != 20 || string(shown.Bits) != string(world.Bits) {
+			t.Errorf("since %d: applying the flips doesn't give the newest frame", since)
+		}
+	}
+
+	// going back to an earlier turn starts again
+	frames.Next(worlds[4], 4)
+	if flips := frames.Since(20); flips.From != -1 || flips.Turn != 4 || len(flips.Cells()) != worlds[4].Count() {
+		t.Errorf("after going back to turn 4 got flips from %d to %d", flips.From, flips.Turn)
+	}
+}
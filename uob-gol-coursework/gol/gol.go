@@ -1,27 +1,53 @@
 package gol
 
-import "fmt"
+import (
+	"image"
+
+	"uk.ac.bris.cs/gameoflife/pattern"
+)
 
 // Params provides the details of how to run the Game of Life and which image to load.
 type Params struct {
 	Turns       int
 	Threads     int
-	ImageWidth  int
-	ImageHeight int
+	ImageWidth  int               // read from the input image or pattern if 0
+	ImageHeight int               // read from the input image or pattern if 0
+	BatchTurns  int               // turns the nodes calculate between halo exchanges, 0 leaves it up to the logic engine
+	Rule        string            // Life-like rule in B/S notation, Conway's B3/S23 if empty
+	Boundary    Boundary          // what happens at the edges of the world
+	Session     string            // the logic engine session to start or attach to, the default session if empty
+	Server      string            // address of the logic engine, SERVER from the environment if empty. The game runs in this process if neither is set
+	Security    Security          // how to connect to the logic engine, this is never sent to it
+	Backend     Backend           // how turns are calculated, hashlife always runs in this process
+	Jump        int               // with hashlife, the log2 of the turns calculated at once. When 0 the jump grows for as long as jumps are quick
+	Input       string            // the image to start from, images/<width>x<height>.pgm if empty
+	OutputDir   string            // where worlds and recordings are saved, out if empty
+	OutputName  string            // template for the names of saved files, in which {turn}, {width}, {height} and {rule} are replaced. {width}x{height}x{turn} if empty
+	Threshold   int               // grey level out of 255 from which a pixel of the input image is an alive cell, 128 if 0
+	Pattern     string            // RLE, Life 1.05/1.06 or plaintext file to start from instead of the image
+	Placement   pattern.Placement // where the pattern goes in the world
+	SaveFormat  string            // pgm, png, or the pattern format worlds are saved in: rle, life105, life106 or cells. pgm if empty
+	Record      Recording         // the turns recorded into an animated GIF, if any
 }
 
 // Run starts the processing of Game of Life. It should initialise channels and goroutines.
+// The turns are calculated by the logic engine if one is configured, otherwise by Threads goroutines in this process.
 func Run(p Params, events chan<- Event, keyPresses <-chan rune) {
+	p, err := FillSize(p)
+	if err != nil {
+		failToStart(events, ErrorReport{Severity: Error, Component: "io", Operation: "reading the size of the world", Cause: err})
+		return
+	}
 
 	ioCommand := make(chan ioCommand)
 	ioIdle := make(chan bool)
-	//Don't read and write one by one
-	inputData := make(chan uint8, p.ImageWidth*p.ImageHeight)
-	outputData := make(chan byte, p.ImageWidth * p.ImageHeight)
+	//Don't read and write one by one, worlds are sent a row at a time
+	inputData := make(chan []byte, p.ImageHeight)
+	outputData := make(chan []byte, p.ImageHeight)
 	filenameChannel := make(chan string, 5)
+	boundsChannel := make(chan image.Rectangle, 1)
 
-	theJankyFilename := fmt.Sprintf("%dx%d", p.ImageWidth, p.ImageHeight)
-	filenameChannel <- theJankyFilename
+	filenameChannel <- p.inputPath()
 
 	distributorChannels := distributorChannels{
 		events,
@@ -30,18 +56,35 @@ func Run(p Params, events chan<- Event, keyPresses <-chan rune) {
 		inputData,
 		outputData,
 		filenameChannel,
+		boundsChannel,
 		keyPresses,
 	}
 
 	ioChannels := ioChannels{
 		command:  ioCommand,
 		idle:     ioIdle,
+		events:   events,
 		filename: filenameChannel,
+		bounds:   boundsChannel,
 		output:   outputData,
 		input:    inputData,
 	}
 
-	controller := createController(p, distributorChannels)
+	engine, err := DialEngine(p)
+	if err != nil {
+		failToStart(events, ErrorReport{Severity: Error, Component: "engine", Operation: "connecting to " + ServerAddress(p), Cause: err})
+		return
+	}
+	controller := createController(p, distributorChannels, engine)
 	go controller.run()
 	go startIo(p, ioChannels)
 }
+
+// reports why the game couldn't start and closes the events channel, as the controller does when it finishes
+func failToStart(events chan<- Event, report ErrorReport) {
+	go func() {
+		events <- report
+		events <- StateChange{0, Quitting}
+		close(events)
+	}()
+}
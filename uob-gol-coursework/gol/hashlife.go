@@ -0,0 +1,97 @@
+package gol
+
+import (
+	"errors"
+	"time"
+
+	"uk.ac.bris.cs/gameoflife/hashlife"
+)
+
+// how many nodes the hashlife universe can hold before the ones the world doesn't use are forgotten
+const maxHashlifeNodes = 1 << 22
+
+// with an adaptive jump, the jump doubles for as long as jumps take less than this
+const quickJump = 50 * time.Millisecond
+
+// the most turns hashlife jumps at once, 2^maxJump
+const maxJump = 60
+
+// Calculates the local engine's world with Hashlife. The torus is a square of 2^level cells, which is the world
+// tiled as many times as it takes to fill it, so the width and height have to be powers of two.
+// On its own the square would shrink by half with every jump, so each jump is made on a tiling big enough
+// that its centre still holds a whole copy of the torus
+type hashlifeCalculator struct {
+	universe *hashlife.Universe
+	torus    *hashlife.Node
+	level    int
+	width    int
+	height   int
+	jump     int  // the log2 of the turns jumped at once
+	adaptive bool // whether the jump grows while jumps are quick
+}
+
+func newHashlifeCalculator(p Params, rule Rule, world PackedWorld) (*hashlifeCalculator, error) {
+	if p.Boundary != Torus {
+		return nil, errors.New("hashlife only works on a torus")
+	}
+	level := 0
+	for 1<<uint(level) < p.ImageWidth || 1<<uint(level) < p.ImageHeight {
+		level++
+	}
+	if 1<<uint(level) < 2 || p.ImageWidth&(p.ImageWidth-1) != 0 || p.ImageHeight&(p.ImageHeight-1) != 0 {
+		return nil, errors.New("hashlife needs the width and height to be powers of two, and at least 2")
+	}
+	h := &hashlifeCalculator{
+		universe: hashlife.New(rule.Next),
+		level:    level,
+		width:    p.ImageWidth,
+		height:   p.ImageHeight,
+		jump:     p.Jump,
+		adaptive: p.Jump == 0,
+	}
+	h.torus = h.universe.Build(level, func(x, y int) bool {
+		return world.Alive(x%h.width, y%h.height)
+	})
+	return h, nil
+}
+
+func (h *hashlifeCalculator) advance(turns int) int {
+	jump := h.jump
+	for jump > 0 && 1<<uint(jump) > turns {
+		jump--
+	}
+	level := h.level + 2
+	if jump+2 > level {
+		level = jump + 2
+	}
+
+	started := time.Now()
+	next := h.universe.Next(h.universe.Tile(h.torus, level), jump)
+	// the centre of the tiling starts 2^(level-2) cells in, a whole number of tori, so its top left corner is the torus
+	for next.Level > h.level {
+		next = next.NW
+	}
+	h.torus = next
+	if h.adaptive && jump == h.jump && h.jump < maxJump && time.Since(started) < quickJump {
+		h.jump++
+	}
+	if h.universe.Nodes() > maxHashlifeNodes {
+		h.universe.Collect(h.torus)
+	}
+	return 1 << uint(jump)
+}
+
+func (h *hashlifeCalculator) world() PackedWorld {
+	world := NewPackedWorld(h.width, h.height)
+	h.torus.AliveCells(func(x, y int) {
+		if x < h.width && y < h.height {
+			world.Set(x, y, true)
+		}
+	})
+	return world
+}
+
+func (h *hashlifeCalculator) aliveCells() int {
+	// the torus holds (2^level)^2 / (width*height) copies of the world
+	return h.torus.Population / (1 << uint(2*h.level) / (h.width * h.height))
+}
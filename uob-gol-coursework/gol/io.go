@@ -1,28 +1,39 @@
 package gol
 
 import (
+	"errors"
 	"fmt"
-	"io/ioutil"
+	"image"
 	"os"
+	"path/filepath"
 	"strconv"
 	"strings"
+	"sync"
 
+	"uk.ac.bris.cs/gameoflife/netpbm"
+	"uk.ac.bris.cs/gameoflife/pattern"
 	"uk.ac.bris.cs/gameoflife/util"
 )
 
 type ioChannels struct {
 	command <-chan ioCommand
 	idle    chan<- bool
+	events  chan<- Event // problems the io runs into are reported as ErrorReport events
 
 	filename <-chan string
-	output   <-chan uint8
-	input    chan<- uint8
+	bounds   <-chan image.Rectangle
+	output   <-chan []byte // worlds to save, a row at a time
+	input    chan<- []byte // the world read in, a row at a time, or a nil row if it couldn't be read
 }
 
 // ioState is the internal ioState of the io goroutine.
 type ioState struct {
 	params   Params
 	channels ioChannels
+	saving   sync.WaitGroup // the saves still being written
+
+	lock    sync.Mutex
+	writing map[string]chan bool // closed when the latest save to each file has been written
 }
 
 // ioCommand allows requesting behaviour from the io (pgm) goroutine.
@@ -39,86 +50,289 @@ const (
 	ioCheckIdle
 )
 
-// writePgmImage receives an array of bytes and writes it to a pgm file.
-func (io *ioState) writePgmImage() {
-	_ = os.Mkdir("out", os.ModePerm)
+// the image the world is read from
+func (p Params) inputPath() string {
+	if p.Input != "" {
+		return p.Input
+	}
+	return filepath.Join("images", fmt.Sprintf("%dx%d.pgm", p.ImageWidth, p.ImageHeight))
+}
 
-	filename := <-io.channels.filename
-	file, ioError := os.Create("out/" + filename + ".pgm")
-	util.Check(ioError)
-	defer file.Close()
+// the directory worlds and recordings are saved in
+func (p Params) outputDir() string {
+	if p.OutputDir != "" {
+		return p.OutputDir
+	}
+	return "out"
+}
 
-	_, _ = file.WriteString("P5\n")
-	//_, _ = file.WriteString("# PGM file writer by pnmmodules (https://github.com/owainkenwayucl/pnmmodules).\n")
-	_, _ = file.WriteString(strconv.Itoa(io.params.ImageWidth))
-	_, _ = file.WriteString(" ")
-	_, _ = file.WriteString(strconv.Itoa(io.params.ImageHeight))
-	_, _ = file.WriteString("\n")
-	_, _ = file.WriteString(strconv.Itoa(255))
-	_, _ = file.WriteString("\n")
-
-	world := make([][]byte, io.params.ImageHeight)
-	for i := range world {
-		world[i] = make([]byte, io.params.ImageWidth)
-	}
-
-	for y := 0; y < io.params.ImageHeight; y++ {
-		for x := 0; x < io.params.ImageWidth; x++ {
-			val := <-io.channels.output
-			//if val != 0 {
-			//	fmt.Println(x, y)
-			//}
-			world[y][x] = val
-		}
+// fills in the output name template for a world of the size from the turn, or the range of turns of a recording.
+// The slash in the rule would make a directory, so it is swapped for a dash
+func (p Params) outputName(width, height int, turn string) string {
+	template := p.OutputName
+	if template == "" {
+		template = "{width}x{height}x{turn}"
 	}
+	rule := p.Rule
+	if rule == "" {
+		rule = Conway
+	}
+	return strings.NewReplacer(
+		"{turn}", turn,
+		"{width}", strconv.Itoa(width),
+		"{height}", strconv.Itoa(height),
+		"{rule}", strings.Replace(rule, "/", "-", -1),
+	).Replace(template)
+}
 
-	for y := 0; y < io.params.ImageHeight; y++ {
-		for x := 0; x < io.params.ImageWidth; x++ {
-			_, ioError = file.Write([]byte{world[y][x]})
-			util.Check(ioError)
+// MaxCells is the most cells a world can have, which is also the logic engine's default limit, so a forged
+// header or size can't make the controller run out of memory allocating the world
+const MaxCells = 1 << 26
+
+// FillSize fills in the width and height the params leave as 0 from the header of the pattern or input image,
+// and checks the world isn't bigger than MaxCells
+func FillSize(p Params) (Params, error) {
+	if p.ImageWidth > 0 && p.ImageHeight > 0 {
+		return p, checkSize(p.ImageWidth, p.ImageHeight)
+	}
+	var width, height int
+	switch {
+	case p.Pattern != "":
+		pat, err := pattern.ReadFile(p.Pattern)
+		if err != nil {
+			return p, err
 		}
+		width, height = pat.Width, pat.Height
+	case p.Input != "":
+		file, err := os.Open(p.Input)
+		if err != nil {
+			return p, err
+		}
+		defer file.Close()
+		img, err := netpbm.DecodeConfig(file)
+		if err != nil {
+			return p, fmt.Errorf("%s: %v", p.Input, err)
+		}
+		width, height = img.Width, img.Height
+	default:
+		return p, errors.New("the width and height are needed to find the input image")
+	}
+
+	if p.ImageWidth <= 0 {
+		p.ImageWidth = width
 	}
+	if p.ImageHeight <= 0 {
+		p.ImageHeight = height
+	}
+	if p.ImageWidth <= 0 || p.ImageHeight <= 0 {
+		return p, fmt.Errorf("can't start from an empty %dx%d world", p.ImageWidth, p.ImageHeight)
+	}
+	return p, checkSize(p.ImageWidth, p.ImageHeight)
+}
 
-	ioError = file.Sync()
-	util.Check(ioError)
+// returns an error if a world of the size would have more than MaxCells cells
+func checkSize(width, height int) error {
+	if width > MaxCells/height {
+		return fmt.Errorf("a %dx%d world has more than the %d cells allowed", width, height, MaxCells)
+	}
+	return nil
+}
 
-	fmt.Println("File", filename, "output done!")
+// reports a problem to the user with an ErrorReport event
+func (io *ioState) report(severity Severity, operation string, err error) {
+	io.channels.events <- ErrorReport{Severity: severity, Component: "io", Operation: operation, Cause: err}
 }
 
-// readPgmImage opens a pgm file and sends its data as an array of bytes.
-func (io *ioState) readPgmImage() {
+// creates the file in the output directory and writes it, making sure it has reached the disk before saying it's done
+func (io *ioState) writeFile(name string, write func(file *os.File) error) error {
+	file, err := os.Create(filepath.Join(io.params.outputDir(), name))
+	if err != nil {
+		return err
+	}
+	err = write(file)
+	if err == nil {
+		err = file.Sync()
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// writePgmImage writes a world to a pgm file.
+// The bounds say where on the plane the image is, which is only away from (0, 0) for an unbounded world,
+// in which case the origin is written as a comment
+func (io *ioState) writePgmImage(filename string, bounds image.Rectangle, world [][]byte) error {
+	img := netpbm.FromCells(netpbm.PGM, world)
+	if bounds.Min != (image.Point{}) {
+		img.Comments = []string{fmt.Sprintf("origin %d %d", bounds.Min.X, bounds.Min.Y)}
+	}
+	return io.writeFile(filename+".pgm", func(file *os.File) error {
+		return netpbm.Encode(file, img)
+	})
+}
+
+// receives the rows of a world the size of the bounds
+func (io *ioState) receiveWorld(bounds image.Rectangle) [][]byte {
+	world := make([][]byte, bounds.Dy())
+	for y := range world {
+		world[y] = <-io.channels.output
+	}
+	return world
+}
+
+// writePngImage writes a world to a png file, with a white pixel for each alive cell.
+func (io *ioState) writePngImage(filename string, world [][]byte) error {
+	return io.writeFile(filename+".png", func(file *os.File) error {
+		return writePNG(file, world)
+	})
+}
+
+// writePattern writes a world to a pattern file in the save format.
+// The origin of an unbounded world is kept by the formats that can say where a pattern is
+func (io *ioState) writePattern(format pattern.Format, filename string, bounds image.Rectangle, world [][]byte) error {
+	p := pattern.FromWorld(world, util.Cell{X: bounds.Min.X, Y: bounds.Min.Y})
+	p.Name = filename
+	p.Rule = io.params.Rule
+	return io.writeFile(filename+format.Extension(), func(file *os.File) error {
+		return pattern.Write(file, p, format)
+	})
+}
+
+// writes the world in the save format
+func (io *ioState) writeWorld(filename string, bounds image.Rectangle, world [][]byte) error {
+	if io.params.SaveFormat == "png" {
+		return io.writePngImage(filename, world)
+	}
+	if format, err := pattern.ParseFormat(io.params.SaveFormat); err == nil {
+		return io.writePattern(format, filename, bounds, world)
+	}
+	return io.writePgmImage(filename, bounds, world)
+}
+
+// receives a world from the controller and saves it in the background, so the controller can get back to
+// the game as soon as the rows have been handed over. A save that fails is reported, the game carries on
+func (io *ioState) save() {
 	filename := <-io.channels.filename
-	data, ioError := ioutil.ReadFile("images/" + filename + ".pgm")
-	util.Check(ioError)
+	bounds := <-io.channels.bounds
+	world := io.receiveWorld(bounds)
+
+	// saves to the same file, like s then k on a paused game, are written one after another in the order
+	// they were made, so the file is never written by two of them at once
+	io.lock.Lock()
+	previous := io.writing[filename]
+	written := make(chan bool)
+	io.writing[filename] = written
+	io.lock.Unlock()
+
+	io.saving.Add(1)
+	go func() {
+		defer io.saving.Done()
+		defer io.written(filename, written)
+		if previous != nil {
+			<-previous
+		}
+		err := os.MkdirAll(io.params.outputDir(), os.ModePerm)
+		if err == nil {
+			err = io.writeWorld(filename, bounds, world)
+		}
+		if err != nil {
+			io.report(Error, "saving "+filename, err)
+			return
+		}
+		fmt.Println("File", filename, "output done!")
+	}()
+}
 
-	fields := strings.Fields(string(data))
+// lets the next save to the file go ahead, forgetting the file if no more saves to it are waiting
+func (io *ioState) written(filename string, written chan bool) {
+	io.lock.Lock()
+	if io.writing[filename] == written {
+		delete(io.writing, filename)
+	}
+	io.lock.Unlock()
+	close(written)
+}
 
-	if fields[0] != "P5" {
-		panic("Not a pgm file")
+// sends the world read in to the controller row by row, or reports why it couldn't be read and sends
+// a nil row to tell the controller there is no world to start from
+func (io *ioState) sendWorld(operation string, world [][]byte, err error) bool {
+	if err != nil {
+		io.report(Error, operation, err)
+		io.channels.input <- nil
+		return false
+	}
+	for _, row := range world {
+		io.channels.input <- row
 	}
+	return true
+}
 
-	width, _ := strconv.Atoi(fields[1])
-	if width != io.params.ImageWidth {
-		panic("Incorrect width")
+// readPattern reads the pattern file in the params and sends it row by row, placed into a
+// world of the image's size.
+func (io *ioState) readPattern() {
+	// the filename is for images, patterns are named in full
+	<-io.channels.filename
+	var world [][]byte
+	p, ioError := pattern.ReadFile(io.params.Pattern)
+	if ioError == nil {
+		if p.Rule != "" && !sameRule(p.Rule, io.params.Rule) {
+			io.report(Warning, "reading "+io.params.Pattern, fmt.Errorf("pattern %s is for %s but the rule is %s", p.Name, p.Rule, io.params.Rule))
+		}
+		world = p.Place(io.params.ImageWidth, io.params.ImageHeight, io.params.Placement)
 	}
 
-	height, _ := strconv.Atoi(fields[2])
-	if height != io.params.ImageHeight {
-		panic("Incorrect height")
+	if io.sendWorld("reading "+io.params.Pattern, world, ioError) {
+		fmt.Println("Pattern", io.params.Pattern, "input done!")
 	}
+}
+
+// returns whether the rules are the same, however they are written
+func sameRule(a, b string) bool {
+	ruleA, errA := ParseRule(a)
+	ruleB, errB := ParseRule(b)
+	return errA == nil && errB == nil && ruleA == ruleB
+}
 
-	maxval, _ := strconv.Atoi(fields[3])
-	if maxval != 255 {
-		panic("Incorrect maxval/bit depth")
+// readPgmImage opens the pbm or pgm file at the input path and sends it row by row, with grey levels
+// turned into alive and dead cells by the threshold.
+func (io *ioState) readPgmImage() {
+	filename := <-io.channels.filename
+	world, ioError := io.decodeImage(filename)
+	if io.sendWorld("reading "+filename, world, ioError) {
+		fmt.Println("File", filename, "input done!")
 	}
+}
 
-	image := []byte(fields[4])
+// reads the image as rows of cells, checking it is the size of the world
+func (io *ioState) decodeImage(filename string) ([][]byte, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
 
-	for _, b := range image {
-		io.channels.input <- b
+	// the size is checked from the header before any pixels are read, so an image can't be bigger than the world
+	config, err := netpbm.DecodeConfig(file)
+	if err != nil {
+		return nil, err
+	}
+	if config.Width != io.params.ImageWidth || config.Height != io.params.ImageHeight {
+		return nil, fmt.Errorf("image is %dx%d but the world is %dx%d", config.Width, config.Height, io.params.ImageWidth, io.params.ImageHeight)
+	}
+	if _, err := file.Seek(0, 0); err != nil {
+		return nil, err
+	}
+	img, err := netpbm.Decode(file)
+	if err != nil {
+		return nil, err
 	}
 
-	fmt.Println("File", filename, "input done!")
+	threshold := io.params.Threshold
+	if threshold == 0 {
+		threshold = netpbm.DefaultThreshold
+	}
+	return img.Cells(threshold), nil
 }
 
 // startIo should be the entrypoint of the io goroutine.
@@ -126,6 +340,7 @@ func startIo(p Params, c ioChannels) {
 	io := ioState{
 		params:   p,
 		channels: c,
+		writing:  make(map[string]chan bool),
 	}
 
 	for {
@@ -133,10 +348,16 @@ func startIo(p Params, c ioChannels) {
 		case command := <-io.channels.command:
 			switch command {
 			case ioInput:
-				io.readPgmImage()
+				if io.params.Pattern != "" {
+					io.readPattern()
+				} else {
+					io.readPgmImage()
+				}
 			case ioOutput:
-				io.writePgmImage()
+				io.save()
 			case ioCheckIdle:
+				// the io is only idle once every save has been written
+				io.saving.Wait()
 				io.channels.idle <- true
 			}
 		}
@@ -0,0 +1,180 @@
+package gol
+
+import (
+	"image"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"uk.ac.bris.cs/gameoflife/netpbm"
+)
+
+// TestFillSize checks sizes left as 0 come from the header of the input image or pattern, and sizes that are
+// given are kept
+func TestFillSize(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gol")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	board := filepath.Join(dir, "board.pgm")
+	glider := filepath.Join(dir, "glider.rle")
+	ioutil.WriteFile(board, []byte("P5\n# made by hand\n30 20\n255\n"), 0644)
+	ioutil.WriteFile(glider, []byte("x = 3, y = 3\nbo$2bo$3o!\n"), 0644)
+
+	tests := []struct {
+		p             Params
+		width, height int
+	}{
+		{Params{Input: board}, 30, 20},
+		{Params{Input: board, ImageWidth: 64}, 64, 20},
+		{Params{Pattern: glider}, 3, 3},
+		{Params{ImageWidth: 16, ImageHeight: 16, Input: filepath.Join(dir, "missing.pgm")}, 16, 16},
+	}
+	for _, test := range tests {
+		p, err := FillSize(test.p)
+		if err != nil {
+			t.Errorf("%+v: %v", test.p, err)
+		} else if p.ImageWidth != test.width || p.ImageHeight != test.height {
+			t.Errorf("%+v: filled in %dx%d, expected %dx%d", test.p, p.ImageWidth, p.ImageHeight, test.width, test.height)
+		}
+	}
+
+	// worlds bigger than MaxCells, whether the size comes from a header or is given
+	huge := filepath.Join(dir, "huge.rle")
+	ioutil.WriteFile(huge, []byte("x = 9000, y = 9000\no!\n"), 0644)
+	tooBig := []Params{{Pattern: huge}, {ImageWidth: 1 << 20, ImageHeight: 1 << 20}, {Input: board, ImageHeight: MaxCells}}
+	for _, p := range append(tooBig, Params{}, Params{ImageWidth: 16}, Params{Input: filepath.Join(dir, "missing.pgm")}, Params{Input: glider}) {
+		if _, err := FillSize(p); err == nil {
+			t.Errorf("%+v: filled in the size without an error", p)
+		}
+	}
+}
+
+func TestOutputName(t *testing.T) {
+	p := Params{Rule: "B36/S23"}
+	if name := p.outputName(64, 32, "100"); name != "64x32x100" {
+		t.Errorf("default name is %q", name)
+	}
+	p.OutputName = "{rule}-{width}-{height}-turn{turn}"
+	if name := p.outputName(64, 32, "0-50"); name != "B36-S23-64-32-turn0-50" {
+		t.Errorf("template filled in as %q", name)
+	}
+}
+
+// TestBadFiles checks that input files that can't be used and saves that fail are reported as errors, and that
+// the events channel is still closed at the end rather than anything panicking
+func TestBadFiles(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gol")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	board := filepath.Join(dir, "board.pgm")
+	corrupt := filepath.Join(dir, "corrupt.pgm")
+	ioutil.WriteFile(board, append([]byte("P5\n30 20\n255\n"), make([]byte, 30*20)...), 0644)
+	ioutil.WriteFile(corrupt, []byte("P5\n30 20\n255\n"), 0644)
+	forged := filepath.Join(dir, "forged.pgm")
+	ioutil.WriteFile(forged, []byte("P5 99999999 99999999 255"), 0644)
+
+	tests := map[string]struct {
+		p        Params
+		finishes bool
+	}{
+		"missing image":       {Params{ImageWidth: 16, ImageHeight: 16, Input: filepath.Join(dir, "missing.pgm")}, false},
+		"missing header":      {Params{Input: filepath.Join(dir, "missing.pgm")}, false},
+		"missing pixels":      {Params{Input: corrupt}, false},
+		"wrong size":          {Params{ImageWidth: 16, ImageHeight: 16, Input: board}, false},
+		"forged size":         {Params{ImageWidth: 16, ImageHeight: 16, Input: forged}, false},
+		"missing pattern":     {Params{ImageWidth: 16, ImageHeight: 16, Pattern: filepath.Join(dir, "missing.rle")}, false},
+		"unwritable save dir": {Params{Input: board, OutputDir: filepath.Join(board, "out")}, true},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			test.p.Turns = 2
+			test.p.Threads = 2
+			events := make(chan Event)
+			go Run(test.p, events, nil)
+
+			var reports []ErrorReport
+			finished := false
+			timeout := time.After(5 * time.Second)
+			for open := true; open; {
+				select {
+				case event, ok := <-events:
+					open = ok
+					switch e := event.(type) {
+					case ErrorReport:
+						reports = append(reports, e)
+					case FinalTurnComplete:
+						finished = true
+					}
+				case <-timeout:
+					t.Fatal("events channel wasn't closed")
+				}
+			}
+
+			if len(reports) != 1 || reports[0].Severity != Error || reports[0].Component != "io" || reports[0].Cause == nil {
+				t.Errorf("got reports %v, expected one error from the io", reports)
+			}
+			if finished != test.finishes {
+				t.Errorf("finished is %v, expected %v", finished, test.finishes)
+			}
+		})
+	}
+}
+
+// TestSameFileSaves checks that saves to the same file are written one after another, so the file ends up
+// holding the last world saved to it
+func TestSameFileSaves(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gol")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	commands := make(chan ioCommand)
+	idle := make(chan bool)
+	filenames := make(chan string, 1)
+	bounds := make(chan image.Rectangle, 1)
+	output := make(chan []byte, 1)
+	go startIo(Params{OutputDir: dir}, ioChannels{
+		command:  commands,
+		idle:     idle,
+		events:   make(chan Event, 10),
+		filename: filenames,
+		bounds:   bounds,
+		output:   output,
+	})
+
+	// the worlds are big enough that writing one isn't over before the next save is made
+	const width = 1 << 16
+	worlds := 10
+	for i := 0; i < worlds; i++ {
+		row := make([]byte, width)
+		row[i] = 255
+		commands <- ioOutput
+		filenames <- "same"
+		bounds <- image.Rect(0, 0, width, 1)
+		output <- row
+	}
+	commands <- ioCheckIdle
+	<-idle
+
+	file, err := os.Open(filepath.Join(dir, "same.pgm"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	img, err := netpbm.Decode(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cells := img.Cells(netpbm.DefaultThreshold)[0]
+	for x := 0; x < worlds; x++ {
+		if alive := cells[x] != 0; alive != (x == worlds-1) {
+			t.Errorf("cell %d is alive: %v, expected only the last world's cell %d to be", x, alive, worlds-1)
+		}
+	}
+}
@@ -0,0 +1,229 @@
+package gol
+
+import "sync"
+
+const alive = 255
+const dead = 0
+
+// Kernel calculates turns of a world of 0/255 bytes using a fixed pool of worker goroutines. It is
+// shared by the nodes and the local engine
+type Kernel struct {
+	strips  chan stripInfo
+	threads int
+}
+
+// NewKernel starts a kernel with the given number of worker goroutines
+func NewKernel(threads int) *Kernel {
+	if threads < 1 {
+		threads = 1
+	}
+	k := &Kernel{strips: make(chan stripInfo), threads: threads}
+	for i := 0; i < threads; i++ {
+		go workerFunction(k.strips)
+	}
+	return k
+}
+
+// Stop ends the kernel's worker goroutines, it can't be used again afterwards
+func (k *Kernel) Stop() {
+	close(k.strips)
+}
+
+type worldInfo struct {
+	width    int
+	height   int
+	rule     Rule
+	boundary Boundary
+}
+
+// Assume that it is always the full width of the world
+// Information about the strip of the world for a worker to process
+type stripInfo struct {
+	world  [][]uint8  //The world to act upon
+	out    *[][]uint8 //The world to write to
+	top    int        //The top row to start on
+	bottom int        //The first non-altered row number
+	w      worldInfo  //There is no Params here, so it needs its own lil' struct
+	wait   *sync.WaitGroup
+}
+
+func workerFunction(strips <-chan stripInfo) {
+	for info := range strips {
+		calculateNextStateOfStrip(&info.world, info.out, info.top, info.bottom, info.w)
+		info.wait.Done()
+	}
+}
+
+// //###################################################################//
+//### The following functions calculate the number of alive cells ###//
+//### neighbouring the given cell. This is much faster than using ###//
+//### a single mod function for all (2.22s -> 1.51s). While they  ###//
+//### could be generalised using higher order functions, this     ###//
+//### counteracts all performance benefits (2.12s), so we elected ###//
+//### to keep with this method.                                   ###//
+//###################################################################//
+
+func calculateNeighbours(x, y int, world [][]byte, height, width int) int {
+	neighbours := 0
+	for i := -1; i <= 1; i++ {
+		for j := -1; j <= 1; j++ {
+			if i != 0 || j != 0 {
+				if world[y+i][x+j] == alive {
+					neighbours++
+				}
+			}
+		}
+	}
+	return neighbours
+}
+
+func calculateNeighboursClampX(x, y int, world [][]byte, height, width int) int {
+	neighbours := 0
+	for i := -1; i <= 1; i++ {
+		for j := -1; j <= 1; j++ {
+			if i != 0 || j != 0 {
+				if world[y+i][Mod(x+j, width)] == alive {
+					neighbours++
+				}
+			}
+		}
+	}
+	return neighbours
+}
+
+func calculateNeighboursDeadX(x, y int, world [][]byte, height, width int) int {
+	neighbours := 0
+	for i := -1; i <= 1; i++ {
+		for j := -1; j <= 1; j++ {
+			if (i != 0 || j != 0) && x+j >= 0 && x+j < width {
+				if world[y+i][x+j] == alive {
+					neighbours++
+				}
+			}
+		}
+	}
+	return neighbours
+}
+
+func calculateNeighboursReflectX(x, y int, world [][]byte, height, width int) int {
+	neighbours := 0
+	for i := -1; i <= 1; i++ {
+		for j := -1; j <= 1; j++ {
+			if i != 0 || j != 0 {
+				// the column beyond the edge is a mirror of the column on the edge
+				column := x + j
+				if column < 0 {
+					column = 0
+				} else if column >= width {
+					column = width - 1
+				}
+				if world[y+i][column] == alive {
+					neighbours++
+				}
+			}
+		}
+	}
+	return neighbours
+}
+
+func setAliveDead(world [][]byte, newWorld [][]byte, x, y, neighbours int, rule *Rule) {
+	if world[y][x] == alive {
+		if rule.Survive[neighbours] {
+			newWorld[y][x] = alive
+		} else {
+			newWorld[y][x] = dead
+		}
+	} else {
+		if rule.Birth[neighbours] {
+			newWorld[y][x] = alive
+		} else {
+			newWorld[y][x] = dead
+		}
+	}
+}
+
+// calculates the rows from top to bottom, the rows either side of these must be included in the world
+func calculateNextStateOfStrip(world, out *[][]byte, top, bottom int, w worldInfo) {
+	// the cells on the left and right edges need the boundary to find their neighbours
+	sideFunc := calculateNeighboursClampX
+	switch w.boundary {
+	case DeadEdges:
+		sideFunc = calculateNeighboursDeadX
+	case Reflect:
+		sideFunc = calculateNeighboursReflectX
+	}
+
+	for y := top; y < bottom; y++ {
+		neighbours := sideFunc(0, y, *world, w.height, w.width)
+		setAliveDead(*world, *out, 0, y, neighbours, &w.rule)
+		for x := 1; x < w.width-1; x++ {
+			neighbours = calculateNeighbours(x, y, *world, w.height, w.width)
+			setAliveDead(*world, *out, x, y, neighbours, &w.rule)
+		}
+		neighbours = sideFunc(w.width-1, y, *world, w.height, w.width)
+		setAliveDead(*world, *out, w.width-1, y, neighbours, &w.rule)
+	}
+}
+
+// NextState calculates the next state of the rows from top to bottom, the rows directly above and below them
+// must be in the world to give them context. Rows outside of these are left dead in the new world
+func (k *Kernel) NextState(world [][]byte, top, bottom, width int, rule Rule, boundary Boundary) [][]byte {
+	height := len(world)
+	newWorld := make([][]byte, height)
+	for i := 0; i < height; i++ {
+		newWorld[i] = make([]byte, width)
+	}
+
+	rows := bottom - top
+	threadsToMake := k.threads
+	for threadsToMake > rows {
+		threadsToMake -= 1
+	}
+	w := worldInfo{width: width, height: height, rule: rule, boundary: boundary}
+	var wg sync.WaitGroup
+	wg.Add(threadsToMake)
+	currentBottom := top
+
+	//May not cleanly divide
+	for i := 0; i < threadsToMake-1; i++ {
+		nextBottom := currentBottom + (rows / threadsToMake) //compiler can optimise this line
+		k.strips <- stripInfo{world: world, out: &newWorld, top: currentBottom, bottom: nextBottom, w: w, wait: &wg}
+		currentBottom = nextBottom
+	}
+	k.strips <- stripInfo{world: world, out: &newWorld, top: currentBottom, bottom: bottom, w: w, wait: &wg}
+
+	wg.Wait()
+
+	return newWorld
+}
+
+// EdgeRows makes the halo rows for one side of a strip from the rows received from the neighbour, or from
+// the strip's own edge rows if nothing is exchanged on that side
+func EdgeRows(edge Edge, received, own [][]byte, width int) [][]byte {
+	rows := make([][]byte, len(own))
+	for i := range rows {
+		switch edge {
+		case NeighbourEdge:
+			rows[i] = received[i]
+		case FlippedEdge:
+			rows[i] = make([]byte, width)
+			for x := range rows[i] {
+				rows[i][x] = received[i][width-1-x]
+			}
+		case DeadEdge:
+			rows[i] = make([]byte, width)
+		case ReflectedEdge:
+			rows[i] = own[len(own)-1-i]
+		}
+	}
+	return rows
+}
+
+// ClearRows kills every cell in the rows
+func ClearRows(rows [][]byte) {
+	for _, row := range rows {
+		for x := range row {
+			row[x] = dead
+		}
+	}
+}
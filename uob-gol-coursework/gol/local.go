@@ -0,0 +1,273 @@
+package gol
+
+import (
+	"errors"
+	"time"
+
+	"uk.ac.bris.cs/gameoflife/util"
+)
+
+var errStopped = errors.New("local engine has stopped")
+var errNotStarted = errors.New("no world is being evolved")
+
+// An engine that runs the game inside this process, used when there is no logic engine to connect to.
+// With the strips backend it splits each turn between Threads goroutines with the same kernel as the nodes,
+// apart from unbounded worlds, which are kept as a set of alive cells.
+// Everything apart from the updates belongs to the run goroutine, the other methods send it commands to carry out between turns
+type localEngine struct {
+	kernel   *Kernel
+	commands chan func()
+	stopped  chan bool // closed when the run goroutine returns
+
+	started    bool // set once a controller has sent the world
+	stopping   bool
+	finished   bool
+	paused     bool
+	p          Params
+	calculator calculator
+	turn       int
+	aliveCells int
+
+	frames   Frames
+	updates  UpdateLog
+	recorded RecordedFrames
+}
+
+// Calculates the turns of the local engine's world with one of the backends
+type calculator interface {
+	// advance calculates at most turns turns, returning how many it calculated
+	advance(turns int) int
+	world() PackedWorld
+	aliveCells() int
+}
+
+// starts a local engine, which runs until it is closed
+func newLocalEngine(threads int) *localEngine {
+	e := &localEngine{kernel: NewKernel(threads), commands: make(chan func()), stopped: make(chan bool)}
+	go e.run()
+	return e
+}
+
+// sends the function to the run goroutine and waits for it to be run
+func (e *localEngine) do(run func()) error {
+	done := make(chan bool)
+	select {
+	case e.commands <- func() { run(); close(done) }:
+	case <-e.stopped:
+		return errStopped
+	}
+	<-done
+	return nil
+}
+
+// sends the function to the run goroutine like do, but only runs it once a controller has sent the world,
+// as there is no calculator before then
+func (e *localEngine) doStarted(run func()) error {
+	var err error
+	if doErr := e.do(func() {
+		if !e.started {
+			err = errNotStarted
+			return
+		}
+		run()
+	}); doErr != nil {
+		return doErr
+	}
+	return err
+}
+
+// calculates turns whenever there is a world to calculate and it isn't paused, carrying out commands in between
+func (e *localEngine) run() {
+	for !e.stopping {
+		if !e.started || e.paused || e.finished {
+			(<-e.commands)()
+			continue
+		}
+		select {
+		case c := <-e.commands:
+			c()
+			continue
+		default:
+		}
+
+		if e.turn < e.p.Turns {
+			// turns that are recorded can't be jumped over
+			turns := e.p.Turns - e.turn
+			if next := e.p.Record.Next(e.turn, e.p.Turns); next >= 0 {
+				turns = next - e.turn
+			}
+			e.turn += e.calculator.advance(turns)
+			e.aliveCells = e.calculator.aliveCells()
+			if e.p.Record.Wants(e.turn, e.p.Turns) {
+				e.recorded.Add(Worldcells{World: e.calculator.world(), Turn: e.turn})
+			}
+			e.updates.Publish(Update{Kind: TurnsCompleted, Turn: e.turn, AliveCells: e.aliveCells})
+		} else {
+			e.finished = true
+			e.updates.Publish(Update{Kind: Finished, Turn: e.turn, AliveCells: e.aliveCells})
+		}
+	}
+	e.kernel.Stop()
+	close(e.stopped)
+}
+
+// stops the run goroutine, waking any controller waiting for updates
+func (e *localEngine) stop() {
+	e.do(func() {
+		e.stopping = true
+		if !e.finished {
+			e.updates.Publish(Update{Kind: Finished, Turn: e.turn, AliveCells: e.aliveCells})
+		}
+	})
+}
+
+func (e *localEngine) Evolve(p Params, world PackedWorld) (attached bool, err error) {
+	rule, err := ParseRule(p.Rule)
+	if err != nil {
+		return
+	}
+	var calculatorErr error
+	err = e.do(func() {
+		if e.started && !e.finished {
+			attached = true
+			return
+		}
+		var c calculator
+		if c, calculatorErr = e.newCalculator(p, rule, world); calculatorErr != nil {
+			return
+		}
+		e.started = true
+		e.finished = false
+		e.p = p
+		e.calculator = c
+		e.turn = 0
+		e.aliveCells = world.Count()
+		e.recorded = RecordedFrames{}
+		if p.Record.Wants(0, p.Turns) {
+			e.recorded.Add(Worldcells{World: world, Turn: 0})
+		}
+		e.updates.Publish(Update{Kind: TurnsCompleted, Turn: 0, AliveCells: e.aliveCells})
+	})
+	if err == nil {
+		err = calculatorErr
+	}
+	return
+}
+
+func (e *localEngine) Pause() (turn int, err error) {
+	err = e.do(func() {
+		e.paused = true
+		turn = e.turn
+		e.updates.Publish(Update{Kind: StateChanged, Turn: e.turn, State: Paused})
+	})
+	return
+}
+
+func (e *localEngine) Resume() error {
+	return e.do(func() {
+		e.paused = false
+		e.updates.Publish(Update{Kind: StateChanged, Turn: e.turn, State: Executing})
+	})
+}
+
+func (e *localEngine) Snapshot() (wc Worldcells, err error) {
+	err = e.doStarted(func() {
+		wc = Worldcells{World: e.calculator.world(), Turn: e.turn}
+		// an unbounded world is sent as the region holding every alive cell
+		if plane, ok := e.calculator.(*sparseCalculator); ok && plane.aliveCells() > 0 {
+			bounds := plane.bounds()
+			wc.World = plane.region(bounds)
+			wc.Origin = util.Cell{X: bounds.Min.X, Y: bounds.Min.Y}
+		}
+	})
+	return
+}
+
+func (e *localEngine) Flips(since int) (flips Flips, err error) {
+	err = e.doStarted(func() {
+		flips = e.frames.Flips(e.calculator.world(), e.turn, since)
+	})
+	return
+}
+
+func (e *localEngine) Recorded(after int) (frames []Worldcells, err error) {
+	err = e.do(func() {
+		frames = e.recorded.After(after)
+	})
+	return
+}
+
+func (e *localEngine) Stats() (info SessionInfo, err error) {
+	err = e.do(func() {
+		info = SessionInfo{ID: e.p.Session, P: e.p, Turn: e.turn, AliveCells: e.aliveCells, Paused: e.paused, Running: e.started && !e.finished}
+		if plane, ok := e.calculator.(*sparseCalculator); ok {
+			info.Bounds = plane.bounds()
+		}
+	})
+	return
+}
+
+func (e *localEngine) Subscribe(after int, timeout time.Duration) ([]Update, error) {
+	return e.updates.Wait(after, timeout), nil
+}
+
+func (e *localEngine) Shutdown() error {
+	e.stop()
+	return nil
+}
+
+func (e *localEngine) Close() error {
+	e.stop()
+	return nil
+}
+
+// returns the calculator for the backend the params ask for
+func (e *localEngine) newCalculator(p Params, rule Rule, world PackedWorld) (calculator, error) {
+	if p.Backend == Hashlife {
+		return newHashlifeCalculator(p, rule, world)
+	}
+	if p.Boundary == Unbounded {
+		return newSparseCalculator(p, rule, world)
+	}
+	return &stripCalculator{kernel: e.kernel, p: p, rule: rule, cells: world.Unpack()}, nil
+}
+
+// Calculates the local engine's world a turn at a time, split between the kernel's threads
+type stripCalculator struct {
+	kernel *Kernel
+	p      Params
+	rule   Rule
+	cells  [][]byte
+}
+
+// calculates the next turn of the whole world, with the halo rows made in the same way the nodes make them
+func (c *stripCalculator) advance(turns int) int {
+	height := len(c.cells)
+	width := c.p.ImageWidth
+	edge := c.p.Boundary.VerticalEdge()
+	above := EdgeRows(edge, c.cells[height-1:], c.cells[:1], width)
+	below := EdgeRows(edge, c.cells[:1], c.cells[height-1:], width)
+
+	contextedWorld := make([][]byte, 0, height+2)
+	contextedWorld = append(contextedWorld, above...)
+	contextedWorld = append(contextedWorld, c.cells...)
+	contextedWorld = append(contextedWorld, below...)
+	c.cells = c.kernel.NextState(contextedWorld, 1, height+1, width, c.rule, c.p.Boundary)[1 : height+1]
+	return 1
+}
+
+func (c *stripCalculator) world() PackedWorld {
+	return PackWorld(c.cells)
+}
+
+func (c *stripCalculator) aliveCells() int {
+	count := 0
+	for _, row := range c.cells {
+		for _, cell := range row {
+			if cell == alive {
+				count++
+			}
+		}
+	}
+	return count
+}
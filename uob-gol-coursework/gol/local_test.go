@@ -0,0 +1,217 @@
+package gol
+
+import (
+	"fmt"
+	"image"
+	"testing"
+	"time"
+
+	"uk.ac.bris.cs/gameoflife/internal/goltest"
+	"uk.ac.bris.cs/gameoflife/util"
+)
+
+// waits for the engine to say it has finished
+func waitForFinish(t *testing.T, engine Engine) {
+	seq := 0
+	for finished := false; !finished; {
+		updates, err := engine.Subscribe(seq, 5*time.Second)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(updates) == 0 {
+			t.Fatal("timed out waiting for the engine to finish")
+		}
+		for _, u := range updates {
+			seq = u.Seq
+			finished = finished || u.Kind == Finished
+		}
+	}
+}
+
+// waits for the engine to finish, then checks the world it finished with
+func checkFinalWorld(t *testing.T, engine Engine, turns int, expected PackedWorld) {
+	waitForFinish(t, engine)
+	wc, err := engine.Snapshot()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if wc.Turn != turns {
+		t.Errorf("finished on turn %d, expected %d", wc.Turn, turns)
+	}
+	if string(wc.World.Bits) != string(expected.Bits) {
+		t.Errorf("world after %d turns is wrong", turns)
+	}
+	info, err := engine.Stats()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.AliveCells != expected.Count() {
+		t.Errorf("%d alive cells after %d turns, expected %d", info.AliveCells, turns, expected.Count())
+	}
+}
+
+// TestLocalEngine checks the local engine against calculating the world one cell at a time, for each
+// boundary and with more threads than there are rows
+func TestLocalEngine(t *testing.T) {
+	const width, height, turns = 21, 18, 12
+	start := PackAliveCells(goltest.RandomCells(width, height), width, height)
+	rule := MustParseRule(Conway)
+
+	for _, boundary := range []Boundary{Torus, DeadEdges, Reflect, KleinBottle} {
+		expected := PackAliveCells(goltest.After(start, width, height, turns, rule, boundary), width, height)
+
+		for _, threads := range []int{1, 4, 32} {
+			t.Run(fmt.Sprintf("%v-%d-threads", boundary, threads), func(t *testing.T) {
+				engine := newLocalEngine(threads)
+				defer engine.Close()
+				p := Params{Turns: turns, Threads: threads, ImageWidth: width, ImageHeight: height, Boundary: boundary}
+				if _, err := engine.Evolve(p, start); err != nil {
+					t.Fatal(err)
+				}
+
+				checkFinalWorld(t, engine, turns, expected)
+			})
+		}
+	}
+}
+
+// TestHashlife checks the hashlife backend against calculating the world one cell at a time, with a number of
+// turns that isn't a power of two so that the last jumps have to be smaller
+func TestHashlife(t *testing.T) {
+	const width, height, turns = 32, 16, 100
+	start := PackAliveCells(goltest.RandomCells(width, height), width, height)
+	for _, rulestring := range []string{Conway, "B36/S23", "B0/S8"} {
+		rule := MustParseRule(rulestring)
+		expected := PackAliveCells(goltest.After(start, width, height, turns, rule, Torus), width, height)
+
+		for _, jump := range []int{0, 1, 5} {
+			t.Run(fmt.Sprintf("%v-jump-%d", rulestring, jump), func(t *testing.T) {
+				engine := newLocalEngine(1)
+				defer engine.Close()
+				p := Params{Turns: turns, ImageWidth: width, ImageHeight: height, Rule: rulestring, Backend: Hashlife, Jump: jump}
+				if _, err := engine.Evolve(p, start); err != nil {
+					t.Fatal(err)
+				}
+				checkFinalWorld(t, engine, turns, expected)
+			})
+		}
+	}
+
+	unsupported := map[string]Params{
+		"dead edges":         {ImageWidth: 16, ImageHeight: 16, Boundary: DeadEdges},
+		"not a power of two": {ImageWidth: 16, ImageHeight: 12},
+		"too small":          {ImageWidth: 1, ImageHeight: 1},
+	}
+	for name, p := range unsupported {
+		engine := newLocalEngine(1)
+		p.Backend = Hashlife
+		if _, err := engine.Evolve(p, NewPackedWorld(p.ImageWidth, p.ImageHeight)); err == nil {
+			t.Errorf("hashlife started with %s", name)
+		}
+		engine.Close()
+	}
+}
+
+// TestRecording checks each backend stops on exactly the turns being recorded, including a jump that has
+// to be cut short, and that frames are forgotten once they have been collected
+func TestRecording(t *testing.T) {
+	const width, height, turns = 16, 16, 30
+	start := PackAliveCells(goltest.RandomCells(width, height), width, height)
+	rule := MustParseRule(Conway)
+	expected := map[int]PackedWorld{0: start}
+	for turn := 1; turn <= turns; turn++ {
+		expected[turn] = PackAliveCells(goltest.After(expected[turn-1], width, height, 1, rule, Torus), width, height)
+	}
+
+	for _, backend := range []Backend{Strips, Hashlife} {
+		t.Run(backend.String(), func(t *testing.T) {
+			engine := newLocalEngine(2)
+			defer engine.Close()
+			p := Params{Turns: turns, ImageWidth: width, ImageHeight: height, Backend: backend, Jump: 4,
+				Record: Recording{From: 3, To: 25, Stride: 5}}
+			if _, err := engine.Evolve(p, start); err != nil {
+				t.Fatal(err)
+			}
+			checkFinalWorld(t, engine, turns, expected[turns])
+
+			frames, err := engine.Recorded(-1)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var recorded []int
+			for _, frame := range frames {
+				recorded = append(recorded, frame.Turn)
+				if string(frame.World.Bits) != string(expected[frame.Turn].Bits) {
+					t.Errorf("world recorded on turn %d is wrong", frame.Turn)
+				}
+			}
+			if fmt.Sprint(recorded) != "[3 8 13 18 23]" {
+				t.Errorf("recorded turns %v, expected [3 8 13 18 23]", recorded)
+			}
+			if frames, _ := engine.Recorded(13); len(frames) != 2 {
+				t.Errorf("%d frames after turn 13, expected 2", len(frames))
+			}
+			if frames, _ := engine.Recorded(0); len(frames) != 2 {
+				t.Errorf("%d frames after the collected ones were asked for again, expected 2", len(frames))
+			}
+		})
+	}
+}
+
+// TestUnbounded sends a glider up and to the left, off the image it started on and into negative coordinates
+func TestUnbounded(t *testing.T) {
+	const turns = 40
+	glider := goltest.Rotate(goltest.Glider, 3, 3)
+	start := PackAliveCells(glider, 8, 8)
+	engine := newLocalEngine(1)
+	defer engine.Close()
+	if _, err := engine.Evolve(Params{Turns: turns, ImageWidth: 8, ImageHeight: 8, Boundary: Unbounded}, start); err != nil {
+		t.Fatal(err)
+	}
+	waitForFinish(t, engine)
+
+	// the glider moves a cell every 4 turns, so the snapshot is the region it has moved to
+	wc, err := engine.Snapshot()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := map[util.Cell]bool{}
+	for _, cell := range glider {
+		expected[util.Cell{X: cell.X - turns/4, Y: cell.Y - turns/4}] = true
+	}
+	cells := wc.AliveCells()
+	if len(cells) != len(expected) {
+		t.Fatalf("got cells %v, expected %v", cells, expected)
+	}
+	for _, cell := range cells {
+		if !expected[cell] {
+			t.Fatalf("got cells %v, expected %v", cells, expected)
+		}
+	}
+
+	info, err := engine.Stats()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.AliveCells != len(glider) {
+		t.Errorf("%d alive cells, expected %d", info.AliveCells, len(glider))
+	}
+	if bounds := image.Rect(-10, -10, -7, -7); info.Bounds != bounds {
+		t.Errorf("bounding box is %v, expected %v", info.Bounds, bounds)
+	}
+}
+
+// TestBeforeEvolve checks that asking for the world before one has been sent is an error rather than a panic
+func TestBeforeEvolve(t *testing.T) {
+	engine := newLocalEngine(1)
+	defer engine.Close()
+	if _, err := engine.Snapshot(); err != errNotStarted {
+		t.Errorf("snapshot before evolving returned %v, expected %v", err, errNotStarted)
+	}
+	if _, err := engine.Flips(-1); err != errNotStarted {
+		t.Errorf("flips before evolving returned %v, expected %v", err, errNotStarted)
+	}
+	if info, err := engine.Stats(); err != nil || info.Running {
+		t.Errorf("stats before evolving are %+v, %v", info, err)
+	}
+}
@@ -0,0 +1,88 @@
+package gol
+
+import "time"
+
+// Struct used by the nodes to subscribe to the logic engine
+type WorkerInfo struct {
+	Address string
+	Threads int // how many threads the node calculates with, used to decide how many rows it gets
+}
+
+// The state of a node in the logic engine's view of the cluster
+type WorkerState int
+
+const (
+	WorkerActive      WorkerState = iota // calculating strips for the sessions
+	WorkerLeaving                        // handing its strips over to the other nodes before leaving
+	WorkerGone                           // left, or was dropped after failing
+	WorkerUnreachable                    // listed in the logic engine's peer file but not connected
+)
+
+func (state WorkerState) String() string {
+	switch state {
+	case WorkerActive:
+		return "active"
+	case WorkerLeaving:
+		return "leaving"
+	case WorkerGone:
+		return "gone"
+	case WorkerUnreachable:
+		return "unreachable"
+	default:
+		return "Incorrect WorkerState"
+	}
+}
+
+// Struct used by the logic engine to describe one of its nodes
+type WorkerStatus struct {
+	Address string
+	Threads int
+	Latency time.Duration // how long the node took to reply to the last ping
+	State   WorkerState
+}
+
+// Struct returned by the logic engine listing its nodes. The epoch increases every time a node joins or leaves
+type Membership struct {
+	Epoch   int
+	Workers []WorkerStatus
+}
+
+// Struct used by the logic engine to hand a node the strip of the world it owns.
+// The node keeps the strip between turns and only swaps its edge rows with its neighbours
+type StripArgs struct {
+	Session string      // the logic engine session the strip belongs to
+	Epoch   int         // changes every time the world is redistributed, so old halos can be ignored
+	Strip   PackedWorld // the rows owned by the node
+	Rule    Rule        // the rule used to calculate each turn
+	Above   string      // address of the node that owns the rows above the strip
+	Below   string      // address of the node that owns the rows below the strip
+
+	Boundary  Boundary // used for the left and right edges of the strip
+	AboveEdge Edge     // where the halo above the strip comes from
+	BelowEdge Edge     // where the halo below the strip comes from
+}
+
+// Struct used by the nodes to send the edge rows of their strip to a neighbour
+type Halo struct {
+	Session   string
+	Epoch     int
+	Turn      int
+	FromAbove bool // true if the rows belong above the receiving strip
+	Rows      PackedWorld
+}
+
+// Struct used by the logic engine to tell the nodes to calculate a turn
+type StepArgs struct {
+	Session string
+	Epoch   int
+	Turn    int
+	Turns   int // number of turns to calculate before replying, the halos exchanged are this many rows deep
+
+	HaloTimeout time.Duration // how long to wait for the neighbours' halos before giving up
+}
+
+// Struct returned by the nodes after calculating a turn
+type StepReply struct {
+	AliveCells  int           // number of alive cells in the node's strip after the turn
+	ComputeTime time.Duration // time spent calculating, not counting waiting for halos
+}
@@ -0,0 +1,173 @@
+package gol
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/gif"
+	"image/png"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// the widest a recording is made when the scale is left to be chosen
+const maxRecordingSize = 512
+
+// how often the controller collects the frames an engine has recorded
+const recordingPoll = 250 * time.Millisecond
+
+// dead cells are black and alive cells are white, as in the PGM images
+var cellPalette = color.Palette{color.Black, color.White}
+
+// Recording says which turns are recorded into an animated GIF
+type Recording struct {
+	From   int // first turn recorded
+	To     int // last turn recorded, the last turn of the run if 0
+	Stride int // turns between frames, nothing is recorded if 0
+	Scale  int // cells along each side of a pixel, chosen to keep the GIF at most 512 pixels across if 0
+	Delay  int // hundredths of a second each frame is shown for, 10 if 0
+}
+
+// Struct used by the controller to collect the frames recorded by its session
+type RecordedArgs struct {
+	Session string
+	After   int // only frames from later turns are wanted, the ones before are dropped
+}
+
+// the last turn recorded in a run of the number of turns
+func (r Recording) last(turns int) int {
+	if r.To == 0 || r.To > turns {
+		return turns
+	}
+	return r.To
+}
+
+// Wants returns whether the turn is recorded in a run of the number of turns
+func (r Recording) Wants(turn, turns int) bool {
+	return r.Stride > 0 && turn >= r.From && turn <= r.last(turns) && (turn-r.From)%r.Stride == 0
+}
+
+// Next returns the first turn after turn that is recorded in a run of the number of turns, or -1 if there isn't one
+func (r Recording) Next(turn, turns int) int {
+	if r.Stride <= 0 {
+		return -1
+	}
+	next := r.From
+	if turn >= r.From {
+		next = r.From + ((turn-r.From)/r.Stride+1)*r.Stride
+	}
+	if next > r.last(turns) {
+		return -1
+	}
+	return next
+}
+
+// RecordedFrames holds the frames an engine has recorded until a controller collects them
+type RecordedFrames struct {
+	frames []Worldcells
+}
+
+// Add keeps the frame until it is collected
+func (r *RecordedFrames) Add(frame Worldcells) {
+	r.frames = append(r.frames, frame)
+}
+
+// After returns the frames from after the turn, forgetting the ones before it, which have already been collected
+func (r *RecordedFrames) After(turn int) []Worldcells {
+	for len(r.frames) > 0 && r.frames[0].Turn <= turn {
+		r.frames = r.frames[1:]
+	}
+	return append([]Worldcells{}, r.frames...)
+}
+
+// turns a world into an image, with each pixel covering scale by scale cells. A pixel is white if any of its cells are alive,
+// so that lone cells and gliders don't disappear when the world is scaled down
+func worldImage(world [][]byte, scale int) *image.Paletted {
+	height := len(world)
+	width := 0
+	if height > 0 {
+		width = len(world[0])
+	}
+	img := image.NewPaletted(image.Rect(0, 0, (width+scale-1)/scale, (height+scale-1)/scale), cellPalette)
+	for y, row := range world {
+		for x, cell := range row {
+			if cell != 0 {
+				img.SetColorIndex(x/scale, y/scale, 1)
+			}
+		}
+	}
+	return img
+}
+
+// writes the world as a PNG, with a pixel for each cell
+func writePNG(out io.Writer, world [][]byte) error {
+	return png.Encode(out, worldImage(world, 1))
+}
+
+// Collects the frames the engine records and writes them to an animated GIF once the last one has been
+// recorded or the run ends, whichever is first
+func (con *Controller) record(done <-chan bool, recorded chan<- bool) {
+	defer close(recorded)
+	r := con.p.Record
+	scale := r.Scale
+	if scale <= 0 {
+		scale = 1
+		for con.p.ImageWidth/scale > maxRecordingSize || con.p.ImageHeight/scale > maxRecordingSize {
+			scale++
+		}
+	}
+	delay := r.Delay
+	if delay <= 0 {
+		delay = 10
+	}
+	last := r.last(con.p.Turns)
+
+	animation := &gif.GIF{}
+	after := r.From - 1
+	collect := func() {
+		frames, err := con.engine.Recorded(after)
+		if err != nil {
+			con.reportError(after, "engine", "collecting recorded frames", err)
+			return
+		}
+		for _, frame := range frames {
+			animation.Image = append(animation.Image, worldImage(frame.World.Unpack(), scale))
+			animation.Delay = append(animation.Delay, delay)
+			after = frame.Turn
+		}
+	}
+
+	ticker := time.NewTicker(recordingPoll)
+	defer ticker.Stop()
+	for stopped := false; !stopped && after < last; {
+		select {
+		case <-ticker.C:
+		case <-done:
+			stopped = true
+		}
+		collect()
+	}
+	if len(animation.Image) == 0 {
+		con.c.events <- ErrorReport{CompletedTurns: last, Severity: Warning, Component: "controller", Operation: "recording", Cause: errors.New("no frames were recorded")}
+		return
+	}
+
+	_ = os.MkdirAll(con.p.outputDir(), os.ModePerm)
+	name := con.p.outputName(con.p.ImageWidth, con.p.ImageHeight, fmt.Sprintf("%d-%d", r.From, after))
+	filename := filepath.Join(con.p.outputDir(), name+".gif")
+	file, err := os.Create(filename)
+	if err == nil {
+		err = gif.EncodeAll(file, animation)
+		if closeErr := file.Close(); err == nil {
+			err = closeErr
+		}
+	}
+	if err != nil {
+		con.reportError(after, "controller", "writing "+filename, err)
+		return
+	}
+	fmt.Println("Recorded", len(animation.Image), "frames to", filename)
+}
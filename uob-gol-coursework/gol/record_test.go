@@ -0,0 +1,60 @@
+package gol
+
+import (
+	"bytes"
+	"image/gif"
+	"testing"
+)
+
+// TestWorldImage checks scaled down images keep lone cells, and that the frames make a GIF that can be read back
+func TestWorldImage(t *testing.T) {
+	world := make([][]byte, 10)
+	for y := range world {
+		world[y] = make([]byte, 7)
+	}
+	world[0][0] = alive
+	world[5][6] = alive
+	world[9][3] = alive
+
+	expected := map[int][]string{
+		1: nil,
+		3: {"#..", "..#", "...", ".#."},
+		4: {"#.", ".#", "#."},
+	}
+	for scale, rows := range expected {
+		img := worldImage(world, scale)
+		if scale == 1 {
+			if img.Bounds().Dx() != 7 || img.Bounds().Dy() != 10 {
+				t.Errorf("unscaled image is %v", img.Bounds())
+			}
+			continue
+		}
+		for y, row := range rows {
+			for x, c := range row {
+				if got := img.ColorIndexAt(x, y) == 1; got != (c == '#') {
+					t.Errorf("scale %d: pixel (%d, %d) alive is %v", scale, x, y, got)
+				}
+			}
+		}
+		if img.Bounds().Dx() != len(rows[0]) || img.Bounds().Dy() != len(rows) {
+			t.Errorf("scale %d: image is %v", scale, img.Bounds())
+		}
+	}
+
+	animation := &gif.GIF{}
+	for frame := 0; frame < 4; frame++ {
+		animation.Image = append(animation.Image, worldImage(world, 2))
+		animation.Delay = append(animation.Delay, 10)
+	}
+	var out bytes.Buffer
+	if err := gif.EncodeAll(&out, animation); err != nil {
+		t.Fatal(err)
+	}
+	read, err := gif.DecodeAll(&out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(read.Image) != 4 {
+		t.Errorf("GIF has %d frames, expected 4", len(read.Image))
+	}
+}
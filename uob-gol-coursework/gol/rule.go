@@ -0,0 +1,89 @@
+package gol
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Conway is the rulestring for the standard Game of Life
+const Conway = "B3/S23"
+
+// Rule is a Life-like rule, indexed by the number of alive neighbours a cell has
+type Rule struct {
+	Birth   [9]bool // a dead cell with this many neighbours becomes alive
+	Survive [9]bool // an alive cell with this many neighbours stays alive
+}
+
+// ParseRule reads a rule in B/S notation such as B3/S23, B36/S23 (HighLife) or B2/S (Seeds).
+// The older S/B form (23/3) is also accepted, and an empty string gives Conway's rule
+func ParseRule(rulestring string) (Rule, error) {
+	var rule Rule
+	if rulestring == "" {
+		rulestring = Conway
+	}
+
+	parts := strings.Split(strings.ToUpper(strings.TrimSpace(rulestring)), "/")
+	if len(parts) != 2 {
+		return rule, fmt.Errorf("rule %q should have the form B<digits>/S<digits>", rulestring)
+	}
+	birth, survive := parts[0], parts[1]
+	if strings.HasPrefix(survive, "B") || strings.HasPrefix(birth, "S") {
+		birth, survive = survive, birth
+	}
+	if strings.HasPrefix(birth, "B") && strings.HasPrefix(survive, "S") {
+		birth, survive = birth[1:], survive[1:]
+	} else if strings.HasPrefix(birth, "B") || strings.HasPrefix(survive, "S") {
+		return rule, fmt.Errorf("rule %q should have the form B<digits>/S<digits>", rulestring)
+	} else {
+		// S/B notation without letters, survival comes first
+		birth, survive = survive, birth
+	}
+
+	for _, set := range []struct {
+		digits string
+		counts *[9]bool
+	}{{birth, &rule.Birth}, {survive, &rule.Survive}} {
+		for _, digit := range set.digits {
+			if digit < '0' || digit > '8' {
+				return rule, fmt.Errorf("rule %q has an invalid neighbour count %q", rulestring, digit)
+			}
+			set.counts[digit-'0'] = true
+		}
+	}
+	return rule, nil
+}
+
+// MustParseRule is like ParseRule but panics if the rule is invalid
+func MustParseRule(rulestring string) Rule {
+	rule, err := ParseRule(rulestring)
+	if err != nil {
+		panic(err)
+	}
+	return rule
+}
+
+// Next returns whether a cell will be alive in the next turn
+func (r Rule) Next(alive bool, neighbours int) bool {
+	if alive {
+		return r.Survive[neighbours]
+	}
+	return r.Birth[neighbours]
+}
+
+// String gives the rule back in B/S notation
+func (r Rule) String() string {
+	var b strings.Builder
+	b.WriteString("B")
+	for i, on := range r.Birth {
+		if on {
+			b.WriteByte(byte('0' + i))
+		}
+	}
+	b.WriteString("/S")
+	for i, on := range r.Survive {
+		if on {
+			b.WriteByte(byte('0' + i))
+		}
+	}
+	return b.String()
+}
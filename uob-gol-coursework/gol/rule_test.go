@@ -0,0 +1,32 @@
+package gol
+
+import "testing"
+
+func TestParseRule(t *testing.T) {
+	tests := []struct {
+		rulestring string
+		expected   string
+	}{
+		{"", "B3/S23"},
+		{"B3/S23", "B3/S23"},
+		{"b36/s23", "B36/S23"},
+		{"B2/S", "B2/S"},
+		{"S23/B3", "B3/S23"},
+		{"23/3", "B3/S23"},
+		{"B/S012345678", "B/S012345678"},
+	}
+	for _, test := range tests {
+		rule, err := ParseRule(test.rulestring)
+		if err != nil {
+			t.Errorf("%q: %v", test.rulestring, err)
+		} else if rule.String() != test.expected {
+			t.Errorf("%q: expected %v, got %v", test.rulestring, test.expected, rule)
+		}
+	}
+
+	for _, bad := range []string{"B3", "B3/23", "B9/S23", "B3/S2x", "B3/S2/S3"} {
+		if _, err := ParseRule(bad); err == nil {
+			t.Errorf("%q: expected an error", bad)
+		}
+	}
+}
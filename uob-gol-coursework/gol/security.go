@@ -0,0 +1,200 @@
+package gol
+
+import (
+	"bytes"
+	"crypto/subtle"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/rpc"
+	"os"
+	"strings"
+	"time"
+)
+
+// how long a new connection has to send its token
+const tokenTimeout = 10 * time.Second
+
+// the reply to a connection that sent the right token
+const tokenAccepted = "OK\n"
+
+// Security is how the connections between the controller, logic engine and nodes are protected. With a
+// certificate or CA the connections use TLS, and with both on every side they use mutual TLS, where a
+// server only accepts clients with a certificate signed by the CA. With a token every connection has to
+// start by sending it, which is only a secret over TLS.
+// Every side has to use the same settings
+type Security struct {
+	CertFile string // certificate this side presents, both as a server and a client
+	KeyFile  string
+	CAFile   string // CA the other side's certificate has to be signed by, the system's CAs if empty
+	Token    string // shared token every connection has to send first, no token is needed if empty
+}
+
+// the flag.Value for -token-file, which reads the token from the file
+type tokenFile struct {
+	token *string
+	name  string
+}
+
+func (t *tokenFile) String() string {
+	if t == nil {
+		return ""
+	}
+	return t.name
+}
+
+func (t *tokenFile) Set(name string) error {
+	token, err := ioutil.ReadFile(name)
+	if err != nil {
+		return err
+	}
+	t.name = name
+	*t.token = strings.TrimSpace(string(token))
+	return nil
+}
+
+// Flags adds the flags for the security settings to the flag set. The token defaults to GOL_TOKEN from the environment
+func (s *Security) Flags(flags *flag.FlagSet) {
+	s.Token = os.Getenv("GOL_TOKEN")
+	flags.StringVar(&s.CertFile, "tls-cert", "", "PEM certificate to present, connections use TLS if this or -tls-ca is set")
+	flags.StringVar(&s.KeyFile, "tls-key", "", "PEM private key for -tls-cert")
+	flags.StringVar(&s.CAFile, "tls-ca", "", "PEM CA that certificates have to be signed by, servers with a CA require clients to present a certificate (mutual TLS)")
+	flags.Var(&tokenFile{token: &s.Token}, "token-file", "file holding the token every connection has to send first, defaults to GOL_TOKEN from the environment")
+}
+
+// TLS returns whether connections use TLS
+func (s Security) TLS() bool {
+	return s.CertFile != "" || s.CAFile != ""
+}
+
+// TLSConfig returns the TLS config for both ends of a connection, or nil if TLS isn't used
+func (s Security) TLSConfig() (*tls.Config, error) {
+	if !s.TLS() {
+		return nil, nil
+	}
+	config := &tls.Config{MinVersion: tls.VersionTLS12}
+	if s.CertFile != "" {
+		cert, err := tls.LoadX509KeyPair(s.CertFile, s.KeyFile)
+		if err != nil {
+			return nil, err
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+	if s.CAFile != "" {
+		pem, err := ioutil.ReadFile(s.CAFile)
+		if err != nil {
+			return nil, err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates in %s", s.CAFile)
+		}
+		config.RootCAs = pool
+		config.ClientCAs = pool
+		config.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	return config, nil
+}
+
+// Listen listens on the address, with TLS if it is used
+func (s Security) Listen(address string) (net.Listener, error) {
+	config, err := s.TLSConfig()
+	if err != nil {
+		return nil, err
+	}
+	if config == nil {
+		return net.Listen("tcp", address)
+	}
+	if len(config.Certificates) == 0 {
+		return nil, errors.New("a certificate is needed to listen with TLS")
+	}
+	return tls.Listen("tcp", address, config)
+}
+
+// Accept serves rpcs on every connection to the listener that sends the right token
+func (s Security) Accept(listener net.Listener, server *rpc.Server) {
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			fmt.Println("Error accepting connection:", err)
+			return
+		}
+		go func() {
+			if err := s.checkToken(conn); err != nil {
+				fmt.Println("Rejected connection from", conn.RemoteAddr(), err)
+				conn.Close()
+				return
+			}
+			server.ServeConn(conn)
+		}()
+	}
+}
+
+// reads the token the connection starts with, replying if it is the right one
+func (s Security) checkToken(conn net.Conn) error {
+	if s.Token == "" {
+		return nil
+	}
+	conn.SetDeadline(time.Now().Add(tokenTimeout))
+	defer conn.SetDeadline(time.Time{})
+
+	// read a byte at a time so that nothing after the token is taken from the rpcs
+	var token bytes.Buffer
+	b := make([]byte, 1)
+	for {
+		if _, err := conn.Read(b); err != nil {
+			return err
+		}
+		if b[0] == '\n' {
+			break
+		}
+		if token.Len() > 4096 {
+			return errors.New("token too long")
+		}
+		token.WriteByte(b[0])
+	}
+	if subtle.ConstantTimeCompare(token.Bytes(), []byte(s.Token)) != 1 {
+		return errors.New("wrong token")
+	}
+	_, err := conn.Write([]byte(tokenAccepted))
+	return err
+}
+
+// Dial connects to the rpc server at the address, with TLS if it is used, and sends the token
+func (s Security) Dial(address string) (*rpc.Client, error) {
+	config, err := s.TLSConfig()
+	if err != nil {
+		return nil, err
+	}
+	var conn net.Conn
+	if config == nil {
+		conn, err = net.Dial("tcp", address)
+	} else {
+		conn, err = tls.Dial("tcp", address, config)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if s.Token != "" {
+		conn.SetDeadline(time.Now().Add(tokenTimeout))
+		reply := make([]byte, len(tokenAccepted))
+		if _, err = conn.Write([]byte(s.Token + "\n")); err == nil {
+			_, err = io.ReadFull(conn, reply)
+		}
+		if err == nil && string(reply) != tokenAccepted {
+			err = errors.New("token rejected")
+		}
+		if err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("%s didn't accept the token: %v", address, err)
+		}
+		conn.SetDeadline(time.Time{})
+	}
+	return rpc.NewClient(conn), nil
+}
@@ -0,0 +1,87 @@
+package gol
+
+import (
+	"errors"
+	"image"
+
+	"uk.ac.bris.cs/gameoflife/util"
+)
+
+// Calculates the local engine's world on an unbounded plane. Only the alive cells are stored, in a set, so the
+// world can grow in any direction and costs nothing where it is empty. The image the world started from is at
+// (0, 0), and cells up or left of it have negative coordinates
+type sparseCalculator struct {
+	p     Params
+	rule  Rule
+	cells map[util.Cell]bool
+}
+
+func newSparseCalculator(p Params, rule Rule, world PackedWorld) (*sparseCalculator, error) {
+	if rule.Birth[0] {
+		return nil, errors.New("rules with B0 would fill an unbounded world with alive cells")
+	}
+	s := &sparseCalculator{p: p, rule: rule, cells: make(map[util.Cell]bool)}
+	for _, cell := range world.AliveCells() {
+		s.cells[cell] = true
+	}
+	return s, nil
+}
+
+// calculates the next turn, only looking at the alive cells and their neighbours
+func (s *sparseCalculator) advance(turns int) int {
+	neighbours := make(map[util.Cell]int, len(s.cells)*4)
+	for cell := range s.cells {
+		for dy := -1; dy <= 1; dy++ {
+			for dx := -1; dx <= 1; dx++ {
+				if dx != 0 || dy != 0 {
+					neighbours[util.Cell{X: cell.X + dx, Y: cell.Y + dy}]++
+				}
+			}
+		}
+	}
+	next := make(map[util.Cell]bool, len(s.cells))
+	for cell, count := range neighbours {
+		if s.rule.Next(s.cells[cell], count) {
+			next[cell] = true
+		}
+	}
+	// cells with no alive neighbours were never counted, so they only survive with S0
+	if s.rule.Survive[0] {
+		for cell := range s.cells {
+			if _, counted := neighbours[cell]; !counted {
+				next[cell] = true
+			}
+		}
+	}
+	s.cells = next
+	return 1
+}
+
+// the window of the plane the size of the starting image, which is what the display shows
+func (s *sparseCalculator) world() PackedWorld {
+	return s.region(image.Rect(0, 0, s.p.ImageWidth, s.p.ImageHeight))
+}
+
+func (s *sparseCalculator) aliveCells() int {
+	return len(s.cells)
+}
+
+// bounds returns the smallest rectangle holding every alive cell, which is empty if there aren't any
+func (s *sparseCalculator) bounds() image.Rectangle {
+	var bounds image.Rectangle
+	for cell := range s.cells {
+		bounds = bounds.Union(image.Rect(cell.X, cell.Y, cell.X+1, cell.Y+1))
+	}
+	return bounds
+}
+
+// region returns the cells inside the rectangle, with its top left corner at (0, 0)
+func (s *sparseCalculator) region(r image.Rectangle) PackedWorld {
+	world := NewPackedWorld(r.Dx(), r.Dy())
+	for cell := range s.cells {
+		if (image.Point{X: cell.X, Y: cell.Y}).In(r) {
+			world.Set(cell.X-r.Min.X, cell.Y-r.Min.Y, true)
+		}
+	}
+	return world
+}
@@ -0,0 +1,75 @@
+package gol
+
+import (
+	"sync"
+	"time"
+)
+
+// the number of updates kept for controllers that haven't collected them yet
+const keptUpdates = 100
+
+// the longest a controller is kept waiting for an update before being replied to with none
+const maxUpdateWait = 30 * time.Second
+
+// UpdateLog keeps the most recent updates of a game for the controllers waiting on them. The zero value is ready to use
+type UpdateLog struct {
+	lock    sync.Mutex
+	updates []Update
+	updated chan bool // closed and replaced when an update is published
+	lastSeq int
+}
+
+// Publish adds the update to the log and wakes every controller waiting for one. A run of turn
+// updates is collapsed into the latest one, as controllers only need the newest count
+func (l *UpdateLog) Publish(u Update) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	l.lastSeq++
+	u.Seq = l.lastSeq
+	if n := len(l.updates); n > 0 && u.Kind == TurnsCompleted && l.updates[n-1].Kind == TurnsCompleted {
+		l.updates[n-1] = u
+	} else {
+		l.updates = append(l.updates, u)
+	}
+	if len(l.updates) > keptUpdates {
+		l.updates = l.updates[len(l.updates)-keptUpdates:]
+	}
+	if l.updated != nil {
+		close(l.updated)
+	}
+	l.updated = make(chan bool)
+}
+
+// returns the updates after seq, along with a channel that is closed when there are more
+func (l *UpdateLog) after(seq int) ([]Update, chan bool) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	if l.updated == nil {
+		l.updated = make(chan bool)
+	}
+	for i, u := range l.updates {
+		if u.Seq > seq {
+			return append([]Update{}, l.updates[i:]...), l.updated
+		}
+	}
+	return nil, l.updated
+}
+
+// Wait returns as soon as there are updates after seq, or with none if the timeout passes first
+func (l *UpdateLog) Wait(seq int, timeout time.Duration) []Update {
+	if timeout <= 0 || timeout > maxUpdateWait {
+		timeout = maxUpdateWait
+	}
+	deadline := time.After(timeout)
+	for {
+		pending, wait := l.after(seq)
+		if len(pending) > 0 {
+			return pending
+		}
+		select {
+		case <-wait:
+		case <-deadline:
+			return nil
+		}
+	}
+}
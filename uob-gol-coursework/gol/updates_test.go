@@ -0,0 +1,77 @@
+package gol
+
+import (
+	"testing"
+	"time"
+)
+
+// TestWaitTimeout checks that waiting on a log with nothing new replies with no updates once the timeout passes
+func TestWaitTimeout(t *testing.T) {
+	var log UpdateLog
+	const timeout = 100 * time.Millisecond
+	start := time.Now()
+	if updates := log.Wait(0, timeout); len(updates) != 0 {
+		t.Errorf("got %v from an empty log", updates)
+	}
+	if waited := time.Since(start); waited < timeout {
+		t.Errorf("replied after %v, before the %v timeout", waited, timeout)
+	}
+
+	log.Publish(Update{Kind: StateChanged, State: Paused})
+	start = time.Now()
+	if updates := log.Wait(1, timeout); len(updates) != 0 || time.Since(start) < timeout {
+		t.Errorf("got %v after %v having already seen every update", updates, time.Since(start))
+	}
+}
+
+// TestWaitWakes checks that a waiting controller is woken as soon as an update is published, and
+// that it is given the updates straight away if there already are some it hasn't seen
+func TestWaitWakes(t *testing.T) {
+	var log UpdateLog
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		log.Publish(Update{Kind: StateChanged, State: Paused})
+	}()
+	start := time.Now()
+	updates := log.Wait(0, 5*time.Second)
+	if len(updates) != 1 || updates[0].Seq != 1 || updates[0].State != Paused {
+		t.Errorf("got %+v after publishing a state change", updates)
+	}
+	if waited := time.Since(start); waited > time.Second {
+		t.Errorf("took %v to be woken by an update", waited)
+	}
+
+	log.Publish(Update{Kind: StateChanged, State: Executing})
+	start = time.Now()
+	if updates := log.Wait(0, 5*time.Second); len(updates) != 2 || time.Since(start) > time.Second {
+		t.Errorf("got %d updates after %v, expected both straight away", len(updates), time.Since(start))
+	}
+}
+
+// TestUpdateLogTrim checks that only the latest keptUpdates updates are kept, and that a run of turn
+// updates is collapsed into the newest
+func TestUpdateLogTrim(t *testing.T) {
+	var log UpdateLog
+	published := keptUpdates + 50
+	for i := 0; i < published; i++ {
+		log.Publish(Update{Kind: StateChanged, Turn: i})
+	}
+	updates := log.Wait(0, time.Second)
+	if len(updates) != keptUpdates {
+		t.Fatalf("kept %d updates, expected %d", len(updates), keptUpdates)
+	}
+	if first, last := updates[0].Seq, updates[keptUpdates-1].Seq; first != published-keptUpdates+1 || last != published {
+		t.Errorf("kept updates %d to %d, expected %d to %d", first, last, published-keptUpdates+1, published)
+	}
+	if updates := log.Wait(published-1, time.Second); len(updates) != 1 || updates[0].Seq != published {
+		t.Errorf("got %+v after asking for the ones after %d", updates, published-1)
+	}
+
+	for turn := 1; turn <= 3; turn++ {
+		log.Publish(Update{Kind: TurnsCompleted, Turn: turn})
+	}
+	updates = log.Wait(published, time.Second)
+	if len(updates) != 1 || updates[0].Turn != 3 || updates[0].Seq != published+3 {
+		t.Errorf("got %+v after three turn updates, expected only the last", updates)
+	}
+}
@@ -0,0 +1,123 @@
+package gol
+
+import (
+	"math/bits"
+
+	"uk.ac.bris.cs/gameoflife/util"
+)
+
+// PackedWorld stores a world using a single bit per cell, which is how the world is sent over rpc.
+// Every row starts on a new byte so that strips of rows can be sliced out without copying.
+type PackedWorld struct {
+	Width  int
+	Height int
+	Bits   []byte
+}
+
+// Creates a packed world with every cell dead
+func NewPackedWorld(width, height int) PackedWorld {
+	return PackedWorld{width, height, make([]byte, rowBytes(width)*height)}
+}
+
+// number of bytes used to store a single row
+func rowBytes(width int) int {
+	return (width + 7) / 8
+}
+
+// Returns whether the cell at x, y is alive
+func (w PackedWorld) Alive(x, y int) bool {
+	return w.Bits[y*rowBytes(w.Width)+x/8]&(1<<uint(x%8)) != 0
+}
+
+// Sets the cell at x, y to alive or dead
+func (w PackedWorld) Set(x, y int, alive bool) {
+	i := y*rowBytes(w.Width) + x/8
+	if alive {
+		w.Bits[i] |= 1 << uint(x%8)
+	} else {
+		w.Bits[i] &^= 1 << uint(x%8)
+	}
+}
+
+// Returns the rows from top up to (but not including) bottom, sharing the same bits
+func (w PackedWorld) Rows(top, bottom int) PackedWorld {
+	stride := rowBytes(w.Width)
+	return PackedWorld{w.Width, bottom - top, w.Bits[top*stride : bottom*stride]}
+}
+
+// Returns the number of alive cells in the world
+func (w PackedWorld) Count() int {
+	count := 0
+	for _, b := range w.Bits {
+		count += bits.OnesCount8(b)
+	}
+	return count
+}
+
+// Joins strips of rows back together into a single world
+func JoinRows(strips ...PackedWorld) PackedWorld {
+	joined := PackedWorld{}
+	for _, strip := range strips {
+		joined.Width = strip.Width
+		joined.Height += strip.Height
+		joined.Bits = append(joined.Bits, strip.Bits...)
+	}
+	return joined
+}
+
+// Turns a world matrix of 0/255 bytes into a packed world
+func PackWorld(world [][]byte) PackedWorld {
+	width := 0
+	if len(world) > 0 {
+		width = len(world[0])
+	}
+	packed := NewPackedWorld(width, len(world))
+	for y, row := range world {
+		for x, cell := range row {
+			if cell == 255 {
+				packed.Set(x, y, true)
+			}
+		}
+	}
+	return packed
+}
+
+// Turns a packed world back into a world matrix of 0/255 bytes
+func (w PackedWorld) Unpack() [][]byte {
+	world := make([][]byte, w.Height)
+	for y := range world {
+		world[y] = make([]byte, w.Width)
+		for x := range world[y] {
+			if w.Alive(x, y) {
+				world[y][x] = 255
+			}
+		}
+	}
+	return world
+}
+
+// Turns a list of alive cells into a packed world
+func PackAliveCells(alive []util.Cell, width, height int) PackedWorld {
+	packed := NewPackedWorld(width, height)
+	for _, cell := range alive {
+		packed.Set(cell.X, cell.Y, true)
+	}
+	return packed
+}
+
+// Turns a packed world into a list of all alive cells
+func (w PackedWorld) AliveCells() []util.Cell {
+	aliveCells := []util.Cell{}
+	stride := rowBytes(w.Width)
+	for y := 0; y < w.Height; y++ {
+		for i, b := range w.Bits[y*stride : (y+1)*stride] {
+			// skip over empty bytes rather than checking each bit
+			for b != 0 {
+				bit := bits.TrailingZeros8(b)
+				aliveCells = append(aliveCells, util.Cell{X: i*8 + bit, Y: y})
+				b &^= 1 << uint(bit)
+			}
+		}
+	}
+	return aliveCells
+}
@@ -0,0 +1,126 @@
+package gol
+
+import (
+	"fmt"
+	"sort"
+	"testing"
+
+	"uk.ac.bris.cs/gameoflife/internal/goltest"
+	"uk.ac.bris.cs/gameoflife/util"
+)
+
+// widths either side of a byte boundary, as every row of a packed world starts on a new byte
+var packedSizes = [][2]int{{1, 1}, {7, 3}, {8, 5}, {9, 4}, {13, 7}, {17, 1}, {64, 16}}
+
+// counts the alive cells of a world matrix one cell at a time
+func countCells(world [][]byte) int {
+	count := 0
+	for _, row := range world {
+		for _, cell := range row {
+			if cell == 255 {
+				count++
+			}
+		}
+	}
+	return count
+}
+
+// sorts cells by row then column, which is the order AliveCells returns them in
+func sortCells(cells []util.Cell) []util.Cell {
+	sort.Slice(cells, func(i, j int) bool {
+		return cells[i].Y < cells[j].Y || cells[i].Y == cells[j].Y && cells[i].X < cells[j].X
+	})
+	return cells
+}
+
+// TestPackRoundTrip checks that packing a world matrix and unpacking it again gives the same cells, and
+// that Count agrees with counting the cells one at a time
+func TestPackRoundTrip(t *testing.T) {
+	for _, size := range packedSizes {
+		width, height := size[0], size[1]
+		t.Run(fmt.Sprintf("%dx%d", width, height), func(t *testing.T) {
+			random := NewPackedWorld(width, height)
+			goltest.Randomise(random, width, height)
+			world := random.Unpack()
+			packed := PackWorld(world)
+			if packed.Width != width || packed.Height != height || len(packed.Bits) != (width+7)/8*height {
+				t.Fatalf("packed world is %dx%d in %d bytes", packed.Width, packed.Height, len(packed.Bits))
+			}
+			unpacked := packed.Unpack()
+			for y := range world {
+				if string(unpacked[y]) != string(world[y]) {
+					t.Fatalf("row %d is %v after a round trip, expected %v", y, unpacked[y], world[y])
+				}
+			}
+			if packed.Count() != countCells(world) {
+				t.Errorf("counted %d alive cells, expected %d", packed.Count(), countCells(world))
+			}
+		})
+	}
+}
+
+// TestRows checks that slicing a world into strips and joining them back together gives the same world,
+// and that setting cells in a strip sets them in the world it was sliced from
+func TestRows(t *testing.T) {
+	for _, size := range packedSizes {
+		width, height := size[0], size[1]
+		t.Run(fmt.Sprintf("%dx%d", width, height), func(t *testing.T) {
+			world := NewPackedWorld(width, height)
+			goltest.Randomise(world, width, height)
+			var strips []PackedWorld
+			for top := 0; top < height; top += 2 {
+				bottom := top + 2
+				if bottom > height {
+					bottom = height
+				}
+				strips = append(strips, world.Rows(top, bottom))
+			}
+			joined := JoinRows(strips...)
+			if joined.Width != width || joined.Height != height || string(joined.Bits) != string(world.Bits) {
+				t.Fatal("joining the strips doesn't give the world they were sliced from")
+			}
+
+			strip := world.Rows(height-1, height)
+			for x := 0; x < width; x++ {
+				strip.Set(x, 0, x%2 == 0)
+			}
+			for x := 0; x < width; x++ {
+				if world.Alive(x, height-1) != (x%2 == 0) {
+					t.Fatalf("cell (%d, %d) wasn't set through the strip", x, height-1)
+				}
+			}
+		})
+	}
+}
+
+// TestCellLists checks the conversions between worlds and lists of alive cells
+func TestCellLists(t *testing.T) {
+	for _, size := range packedSizes {
+		width, height := size[0], size[1]
+		t.Run(fmt.Sprintf("%dx%d", width, height), func(t *testing.T) {
+			world := NewPackedWorld(width, height)
+			goltest.Randomise(world, width, height)
+			cells := world.AliveCells()
+			if len(cells) != world.Count() {
+				t.Fatalf("got %d alive cells, expected %d", len(cells), world.Count())
+			}
+			for _, cell := range cells {
+				if cell.X < 0 || cell.X >= width || cell.Y < 0 || cell.Y >= height || !world.Alive(cell.X, cell.Y) {
+					t.Fatalf("cell %v isn't alive", cell)
+				}
+			}
+			if packed := PackAliveCells(cells, width, height); string(packed.Bits) != string(world.Bits) {
+				t.Error("packing the alive cells doesn't give the world they came from")
+			}
+
+			matrix := CalculateWorld(cells, height, width)
+			if packed := PackWorld(matrix); string(packed.Bits) != string(world.Bits) {
+				t.Error("the world matrix made from the alive cells is wrong")
+			}
+			fromMatrix := sortCells(CalculateAliveCells(matrix))
+			if fmt.Sprint(fromMatrix) != fmt.Sprint(cells) {
+				t.Errorf("got %v from the world matrix, expected %v", fromMatrix, cells)
+			}
+		})
+	}
+}
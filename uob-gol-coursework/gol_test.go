@@ -33,6 +33,8 @@ func TestGol(t *testing.T) {
 						switch e := event.(type) {
 						case gol.FinalTurnComplete:
 							cells = e.Alive
+						case gol.ErrorReport:
+							t.Error(e)
 						}
 					}
 					assertEqualBoard(t, cells, expectedAlive, p)
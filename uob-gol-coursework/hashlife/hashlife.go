@@ -0,0 +1,245 @@
+// Package hashlife calculates Life-like rules with Gosper's Hashlife algorithm. The world is a quadtree
+// where every distinct square of cells is only stored once, and the square each node becomes after
+// 2^k generations is remembered on the node, so patterns that repeat in space or time cost next to
+// nothing to calculate however many generations are asked for
+package hashlife
+
+import "math"
+
+// Node is a square of 2^Level by 2^Level cells. A node is never changed once it has been made, and a
+// universe never makes two nodes for the same square, so equal squares are always the same pointer
+type Node struct {
+	NW, NE, SW, SE *Node // the quarters of the square, nil at level 0
+	Level          int
+	Population     int // the number of alive cells, stuck at math.MaxInt64 for squares too big to count
+
+	result   *Node // the centre of the square after 2^(Level-2) generations
+	step     *Node // the centre of the square after 2^(stepJump-1) generations
+	stepJump int   // one more than the log2 of the generations step is for, 0 if there is no step
+}
+
+// the key nodes are found by when making sure the same square isn't made twice
+type quad struct {
+	nw, ne, sw, se *Node
+}
+
+// Universe makes nodes and calculates what they become under a rule
+type Universe struct {
+	rule  func(alive bool, neighbours int) bool
+	quiet bool // whether empty space stays empty, which isn't true for rules with B0
+	nodes map[quad]*Node
+	dead  *Node
+	alive *Node
+	empty []*Node // the empty square at each level
+}
+
+// New returns a universe for the rule, which says whether a cell with that many alive neighbours is alive in the next generation
+func New(rule func(alive bool, neighbours int) bool) *Universe {
+	u := &Universe{
+		rule:  rule,
+		quiet: !rule(false, 0),
+		nodes: make(map[quad]*Node),
+		dead:  &Node{},
+		alive: &Node{Population: 1},
+	}
+	u.empty = []*Node{u.dead}
+	return u
+}
+
+// Cell returns the level 0 node for a cell
+func (u *Universe) Cell(alive bool) *Node {
+	if alive {
+		return u.alive
+	}
+	return u.dead
+}
+
+// Join returns the node made of the four quarters, which all have to be at the same level
+func (u *Universe) Join(nw, ne, sw, se *Node) *Node {
+	key := quad{nw, ne, sw, se}
+	if n, ok := u.nodes[key]; ok {
+		return n
+	}
+	n := &Node{NW: nw, NE: ne, SW: sw, SE: se, Level: nw.Level + 1, Population: nw.Population}
+	for _, q := range []*Node{ne, sw, se} {
+		if n.Population > math.MaxInt64-q.Population {
+			n.Population = math.MaxInt64
+		} else {
+			n.Population += q.Population
+		}
+	}
+	u.nodes[key] = n
+	return n
+}
+
+// Empty returns the empty square at the level
+func (u *Universe) Empty(level int) *Node {
+	for len(u.empty) <= level {
+		e := u.empty[len(u.empty)-1]
+		u.empty = append(u.empty, u.Join(e, e, e, e))
+	}
+	return u.empty[level]
+}
+
+// Build returns the square at the level with the cells alive says are alive, where (0, 0) is the top left corner
+func (u *Universe) Build(level int, alive func(x, y int) bool) *Node {
+	return u.build(level, 0, 0, alive)
+}
+
+func (u *Universe) build(level, x, y int, alive func(x, y int) bool) *Node {
+	if level == 0 {
+		return u.Cell(alive(x, y))
+	}
+	half := 1 << uint(level-1)
+	return u.Join(
+		u.build(level-1, x, y, alive),
+		u.build(level-1, x+half, y, alive),
+		u.build(level-1, x, y+half, alive),
+		u.build(level-1, x+half, y+half, alive))
+}
+
+// Tile returns the square at the level covered in copies of the node, which is how a torus looks from the inside
+func (u *Universe) Tile(n *Node, level int) *Node {
+	for n.Level < level {
+		n = u.Join(n, n, n, n)
+	}
+	return n
+}
+
+// Centre returns the middle half of the node, which has to be at least level 2
+func (u *Universe) Centre(n *Node) *Node {
+	if n.Level < 2 {
+		panic("hashlife: a node below level 2 has no centre")
+	}
+	return u.Join(n.NW.SE, n.NE.SW, n.SW.NE, n.SE.NW)
+}
+
+// AliveCells calls visit with every alive cell in the node, where (0, 0) is its top left corner.
+// Empty quarters are skipped, so this is quick for sparse squares
+func (n *Node) AliveCells(visit func(x, y int)) {
+	n.aliveCells(0, 0, visit)
+}
+
+func (n *Node) aliveCells(x, y int, visit func(x, y int)) {
+	if n.Population == 0 {
+		return
+	}
+	if n.Level == 0 {
+		visit(x, y)
+		return
+	}
+	half := 1 << uint(n.Level-1)
+	n.NW.aliveCells(x, y, visit)
+	n.NE.aliveCells(x+half, y, visit)
+	n.SW.aliveCells(x, y+half, visit)
+	n.SE.aliveCells(x+half, y+half, visit)
+}
+
+// Nodes returns the number of nodes the universe is holding on to
+func (u *Universe) Nodes() int {
+	return len(u.nodes)
+}
+
+// Collect forgets every node that isn't part of one of the roots, along with every remembered result,
+// to free memory. Nodes that aren't part of the roots mustn't be used afterwards
+func (u *Universe) Collect(roots ...*Node) {
+	u.nodes = make(map[quad]*Node)
+	u.empty = []*Node{u.dead}
+	for _, root := range roots {
+		u.keep(root)
+	}
+}
+
+func (u *Universe) keep(n *Node) {
+	if n.Level == 0 {
+		return
+	}
+	key := quad{n.NW, n.NE, n.SW, n.SE}
+	if _, ok := u.nodes[key]; ok {
+		return
+	}
+	n.result, n.step, n.stepJump = nil, nil, 0
+	u.nodes[key] = n
+	u.keep(n.NW)
+	u.keep(n.NE)
+	u.keep(n.SW)
+	u.keep(n.SE)
+}
+
+// Next returns the centre of the node after 2^jump generations. The node has to be at least level 2,
+// and jump can be at most its level minus 2
+func (u *Universe) Next(n *Node, jump int) *Node {
+	if n.Level < 2 || jump < 0 || jump > n.Level-2 {
+		panic("hashlife: can't jump that far with a node that small")
+	}
+	return u.next(n, jump)
+}
+
+func (u *Universe) next(n *Node, jump int) *Node {
+	full := jump == n.Level-2
+	switch {
+	case n.Population == 0 && u.quiet:
+		return u.Empty(n.Level - 1)
+	case full && n.result != nil:
+		return n.result
+	case !full && n.stepJump == jump+1:
+		return n.step
+	}
+
+	var result *Node
+	if n.Level == 2 {
+		result = u.base(n)
+	} else {
+		// the nine overlapping squares half the size of the node
+		squares := [9]*Node{
+			n.NW, u.Join(n.NW.NE, n.NE.NW, n.NW.SE, n.NE.SW), n.NE,
+			u.Join(n.NW.SW, n.NW.SE, n.SW.NW, n.SW.NE), u.Join(n.NW.SE, n.NE.SW, n.SW.NE, n.SE.NW), u.Join(n.NE.SW, n.NE.SE, n.SE.NW, n.SE.NE),
+			n.SW, u.Join(n.SW.NE, n.SE.NW, n.SW.SE, n.SE.SW), n.SE,
+		}
+		// going full speed, the first half of the generations are calculated on the nine squares and the second half
+		// on the four squares made from them. Otherwise the nine squares only give their centres and every generation
+		// is calculated on the four
+		rest := jump
+		for i, s := range squares {
+			if full {
+				squares[i] = u.next(s, s.Level-2)
+				rest = s.Level - 2
+			} else {
+				squares[i] = u.Centre(s)
+			}
+		}
+		result = u.Join(
+			u.next(u.Join(squares[0], squares[1], squares[3], squares[4]), rest),
+			u.next(u.Join(squares[1], squares[2], squares[4], squares[5]), rest),
+			u.next(u.Join(squares[3], squares[4], squares[6], squares[7]), rest),
+			u.next(u.Join(squares[4], squares[5], squares[7], squares[8]), rest))
+	}
+
+	if full {
+		n.result = result
+	} else {
+		n.step = result
+		n.stepJump = jump + 1
+	}
+	return result
+}
+
+// calculates one generation of the middle 2x2 cells of a level 2 node
+func (u *Universe) base(n *Node) *Node {
+	var cells [4][4]bool
+	n.AliveCells(func(x, y int) {
+		cells[y][x] = true
+	})
+	next := func(x, y int) *Node {
+		neighbours := 0
+		for dy := -1; dy <= 1; dy++ {
+			for dx := -1; dx <= 1; dx++ {
+				if (dx != 0 || dy != 0) && cells[y+dy][x+dx] {
+					neighbours++
+				}
+			}
+		}
+		return u.Cell(u.rule(cells[y][x], neighbours))
+	}
+	return u.Join(next(1, 1), next(2, 1), next(1, 2), next(2, 2))
+}
@@ -0,0 +1,87 @@
+package hashlife
+
+import (
+	"fmt"
+	"testing"
+
+	"uk.ac.bris.cs/gameoflife/internal/goltest"
+)
+
+// TestTorus checks jumps of every size on a torus, made by tiling a square, against calculating one generation at a time
+func TestTorus(t *testing.T) {
+	const level, size = 4, 16
+	rules := map[string]goltest.RuleFunc{
+		"B3/S23": goltest.Conway,
+		"B0/S8":  func(alive bool, neighbours int) bool { return alive && neighbours == 8 || !alive && neighbours == 0 },
+	}
+	for name, rule := range rules {
+		cells := goltest.NewCells(size, size)
+		goltest.Randomise(cells, size, size)
+
+		u := New(rule)
+		torus := u.Build(level, func(x, y int) bool { return cells[y][x] })
+		expected := cells
+		generations := 0
+		for jump := 0; jump < 7; jump++ {
+			t.Run(fmt.Sprintf("%s-jump-%d", name, jump), func(t *testing.T) {
+				// the centre of a tiling at least two levels bigger than the torus lines up with it
+				tiled := u.Tile(torus, jump+2)
+				if tiled.Level < level+2 {
+					tiled = u.Tile(torus, level+2)
+				}
+				next := u.Next(tiled, jump)
+				for next.Level > level {
+					next = next.NW
+				}
+				torus = next
+				for ; generations < 2<<uint(jump)-1; generations++ {
+					next := goltest.NewCells(size, size)
+					goltest.Turn(expected, next, size, size, rule, goltest.Torus)
+					expected = next
+				}
+
+				got := goltest.NewCells(size, size)
+				torus.AliveCells(func(x, y int) { got[y][x] = true })
+				for y := range got {
+					for x := range got[y] {
+						if got[y][x] != expected[y][x] {
+							t.Fatalf("cell (%d, %d) is wrong after %d generations", x, y, generations)
+						}
+					}
+				}
+			})
+		}
+	}
+}
+
+// TestGliderBillions sends a glider across a plane for 2^40 generations, which is only possible because every
+// square of the glider's path is the same as one already calculated
+func TestGliderBillions(t *testing.T) {
+	const jump = 40
+	u := New(goltest.Conway)
+	glider := map[[2]int]bool{{1, 0}: true, {2, 1}: true, {0, 2}: true, {1, 2}: true, {2, 2}: true}
+	// the glider starts in the top left of the centre of a square big enough that it can't reach the edge
+	level := jump + 3
+	corner := u.Build(3, func(x, y int) bool { return glider[[2]int{x, y}] })
+	for l := 3; l < level-2; l++ {
+		e := u.Empty(l)
+		corner = u.Join(corner, e, e, e)
+	}
+	e := u.Empty(level - 2)
+	world := u.Join(u.Join(e, e, e, e), u.Join(e, e, e, e), u.Join(e, e, e, e), u.Join(corner, e, e, e))
+
+	next := u.Next(world, jump)
+	if next.Population != 5 {
+		t.Fatalf("%d cells after 2^%d generations, expected the glider's 5", next.Population, jump)
+	}
+	// a glider moves a cell diagonally every 4 generations, so it ends 2^38 cells down and right of where it started,
+	// and the centre starts a quarter of the way across the world
+	offset := 1<<uint(level-2) + 1<<uint(jump-2)
+	var cells [][2]int
+	next.AliveCells(func(x, y int) { cells = append(cells, [2]int{x - offset, y - offset}) })
+	for _, cell := range cells {
+		if !glider[cell] {
+			t.Fatalf("glider is at %v after 2^%d generations, expected it to be in the same phase 2^%d cells on", cells, jump, jump-2)
+		}
+	}
+}
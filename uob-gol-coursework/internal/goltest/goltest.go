@@ -0,0 +1,146 @@
+// Package goltest has the slow but obviously correct Game of Life the tests check the faster backends
+// against, along with the worlds and patterns they start from, so that every package uses the same ones
+// rather than its own copy of them.
+// It only uses small interfaces, which gol's own types satisfy, so that gol's tests can import it too
+package goltest
+
+import (
+	"math/rand"
+
+	"uk.ac.bris.cs/gameoflife/util"
+)
+
+// Glider is a glider in a 3x3 box heading down and to the right, with its cells in row order
+var Glider = []util.Cell{{X: 1, Y: 0}, {X: 2, Y: 1}, {X: 0, Y: 2}, {X: 1, Y: 2}, {X: 2, Y: 2}}
+
+// World is a grid of cells that can be read and changed, such as a gol.PackedWorld
+type World interface {
+	Alive(x, y int) bool
+	Set(x, y int, alive bool)
+}
+
+// Rule decides whether a cell is alive on the next turn, such as a gol.Rule
+type Rule interface {
+	Next(alive bool, neighbours int) bool
+}
+
+// Boundary maps a cell that may be off the edge of the world to the cell it stands for, ok is false if
+// it stands for a dead cell. gol.Boundary is one
+type Boundary interface {
+	Cell(x, y, width, height int) (cx, cy int, ok bool)
+}
+
+// RuleFunc lets an ordinary function be used as a Rule
+type RuleFunc func(alive bool, neighbours int) bool
+
+func (f RuleFunc) Next(alive bool, neighbours int) bool {
+	return f(alive, neighbours)
+}
+
+// Conway is the rule of the standard Game of Life, B3/S23
+var Conway = RuleFunc(func(alive bool, neighbours int) bool {
+	return neighbours == 3 || alive && neighbours == 2
+})
+
+type torus struct{}
+
+func (torus) Cell(x, y, width, height int) (int, int, bool) {
+	return (x%width + width) % width, (y%height + height) % height, true
+}
+
+// Torus wraps the edges of the world round, for packages that don't have gol's boundaries
+var Torus Boundary = torus{}
+
+// Cells is a World of booleans for packages that don't have gol's worlds
+type Cells [][]bool
+
+// NewCells creates a world of cells with every cell dead
+func NewCells(width, height int) Cells {
+	cells := make(Cells, height)
+	for y := range cells {
+		cells[y] = make([]bool, width)
+	}
+	return cells
+}
+
+func (c Cells) Alive(x, y int) bool {
+	return c[y][x]
+}
+
+func (c Cells) Set(x, y int, alive bool) {
+	c[y][x] = alive
+}
+
+// Turn calculates the next turn of world into next one cell at a time, without splitting the world up
+func Turn(world, next World, width, height int, rule Rule, boundary Boundary) {
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			neighbours := 0
+			for i := -1; i <= 1; i++ {
+				for j := -1; j <= 1; j++ {
+					cx, cy, ok := boundary.Cell(x+j, y+i, width, height)
+					if (i != 0 || j != 0) && ok && world.Alive(cx, cy) {
+						neighbours++
+					}
+				}
+			}
+			next.Set(x, y, rule.Next(world.Alive(x, y), neighbours))
+		}
+	}
+}
+
+// After calculates turns turns of the world one cell at a time, returning the cells alive at the end
+func After(world World, width, height, turns int, rule Rule, boundary Boundary) []util.Cell {
+	current, next := NewCells(width, height), NewCells(width, height)
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			current[y][x] = world.Alive(x, y)
+		}
+	}
+	for turn := 0; turn < turns; turn++ {
+		Turn(current, next, width, height, rule, boundary)
+		current, next = next, current
+	}
+	return current.AliveCells()
+}
+
+// AliveCells lists the alive cells in row order
+func (c Cells) AliveCells() []util.Cell {
+	var cells []util.Cell
+	for y := range c {
+		for x, alive := range c[y] {
+			if alive {
+				cells = append(cells, util.Cell{X: x, Y: y})
+			}
+		}
+	}
+	return cells
+}
+
+// Rotate turns the cells half way round in a box of the size, so a glider heads the opposite way
+func Rotate(cells []util.Cell, width, height int) []util.Cell {
+	rotated := make([]util.Cell, len(cells))
+	for i, cell := range cells {
+		rotated[i] = util.Cell{X: width - 1 - cell.X, Y: height - 1 - cell.Y}
+	}
+	return rotated
+}
+
+// RandomCells lists the alive cells of a world of the size with roughly a third of them alive, the
+// same ones that Randomise picks
+func RandomCells(width, height int) []util.Cell {
+	cells := NewCells(width, height)
+	Randomise(cells, width, height)
+	return cells.AliveCells()
+}
+
+// Randomise sets roughly a third of the cells of the world alive. The same cells are picked every
+// time, so a failing test fails the same way when it is run again
+func Randomise(world World, width, height int) {
+	random := rand.New(rand.NewSource(1))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			world.Set(x, y, random.Intn(3) == 0)
+		}
+	}
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"time"
+
+	"uk.ac.bris.cs/gameoflife/gol"
+)
+
+// how much slower than an even split the slowest node has to be before the world is split up again
+const imbalanceThreshold = 1.1
+
+// how much each new measurement of a node's throughput counts towards its average
+const throughputSmoothing = 0.3
+
+// returns how many cell-turns per second the node manages, estimating from its threads if it
+// hasn't calculated a turn yet
+func (s *session) expectedThroughput(w worker) float64 {
+	if throughput, ok := s.g.measuredThroughput(w.address); ok {
+		return throughput
+	}
+	// nodes that haven't been measured are assumed to be as fast per thread as the ones that have
+	var measured, threads float64
+	for _, other := range s.active {
+		if throughput, ok := s.g.measuredThroughput(other.address); ok {
+			measured += throughput
+			threads += other.capacity()
+		}
+	}
+	if threads == 0 || measured == 0 {
+		return w.capacity()
+	}
+	return measured / threads * w.capacity()
+}
+
+// splits the rows of the world between the nodes in proportion to their throughput. Every node gets at
+// least as many rows as the turns batched between halo exchanges, so that a slow node with a thin strip
+// doesn't hold every other node back to smaller batches, unless the world is too small for that
+func (s *session) partition(workers []worker) []int {
+	throughputs := make([]float64, len(workers))
+	var total float64
+	for i, w := range workers {
+		throughputs[i] = s.expectedThroughput(w)
+		total += throughputs[i]
+	}
+
+	least := s.batchTurns()
+	if least > s.p.ImageHeight/len(workers) {
+		least = s.p.ImageHeight / len(workers)
+	}
+	if least < 1 {
+		least = 1
+	}
+	heights := make([]int, len(workers))
+	spare := s.p.ImageHeight - least*len(workers)
+	assigned := 0
+	for i := range heights {
+		heights[i] = least + int(float64(spare)*throughputs[i]/total)
+		assigned += heights[i]
+	}
+	// hand out the rows lost to rounding down one at a time
+	for i := 0; assigned < s.p.ImageHeight; i = (i + 1) % len(heights) {
+		heights[i]++
+		assigned++
+	}
+	return heights
+}
+
+// returns the average throughput of the node, which is shared by every session
+func (g *Game) measuredThroughput(address string) (float64, bool) {
+	g.throughputLock.Lock()
+	defer g.throughputLock.Unlock()
+	throughput, ok := g.throughput[address]
+	return throughput, ok
+}
+
+// updates the throughput of each node from the time it took to calculate its strip
+func (s *session) recordStep(replies []gol.StepReply, turns int) {
+	g := s.g
+	g.throughputLock.Lock()
+	defer g.throughputLock.Unlock()
+	for i, reply := range replies {
+		if reply.ComputeTime <= 0 {
+			continue
+		}
+		address := s.active[i].address
+		sample := float64(s.heights[i]*s.p.ImageWidth*turns) / reply.ComputeTime.Seconds()
+		if old, ok := g.throughput[address]; ok {
+			sample = old + throughputSmoothing*(sample-old)
+		}
+		g.throughput[address] = sample
+	}
+}
+
+// returns whether the slowest node is taking long enough compared to an even split of the work
+// that it is worth gathering the world and splitting it up again
+func (s *session) shouldRebalance() bool {
+	if s.g.rebalanceInterval <= 0 || time.Since(s.lastBalance) < s.g.rebalanceInterval {
+		return false
+	}
+	var total, slowest float64
+	for i, w := range s.active {
+		throughput := s.expectedThroughput(w)
+		total += throughput
+		if t := float64(s.heights[i]) / throughput; t > slowest {
+			slowest = t
+		}
+	}
+	s.lastBalance = time.Now()
+	even := float64(s.p.ImageHeight) / total
+	return slowest > even*imbalanceThreshold && len(s.active) > 1
+}
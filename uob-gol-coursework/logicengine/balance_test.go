@@ -0,0 +1,124 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"uk.ac.bris.cs/gameoflife/gol"
+)
+
+// makes a session on a logic engine whose nodes have been timed at the throughputs, with the nodes
+// named by their index
+func balanceSession(height, batch int, throughputs ...float64) (*session, []worker) {
+	g := &Game{throughput: make(map[string]float64), batchTurns: 1, rebalanceInterval: time.Minute}
+	workers := make([]worker, len(throughputs))
+	for i, throughput := range throughputs {
+		workers[i] = worker{address: fmt.Sprint(i), threads: 1}
+		g.throughput[workers[i].address] = throughput
+	}
+	s := &session{g: g, p: gol.Params{ImageWidth: 64, ImageHeight: height, BatchTurns: batch}}
+	return s, workers
+}
+
+// TestPartition checks that every row is given to exactly one node, that every node gets enough rows
+// for a whole batch of turns, and that faster nodes get more rows
+func TestPartition(t *testing.T) {
+	tests := []struct {
+		height, batch int
+		throughputs   []float64
+		least         int // the fewest rows any node should get
+	}{
+		{64, 1, []float64{1, 1, 1, 1}, 1},
+		{64, 4, []float64{1, 2, 3, 10}, 4},
+		{100, 8, []float64{1, 100}, 8},
+		{7, 1, []float64{5, 1, 3}, 1},
+		{12, 16, []float64{1, 2, 3}, 4}, // too few rows for a whole batch each, so they are shared out evenly
+		{512, 0, []float64{3, 1, 1, 1, 1, 1}, 1},
+	}
+	for _, test := range tests {
+		s, workers := balanceSession(test.height, test.batch, test.throughputs...)
+		heights := s.partition(workers)
+		name := fmt.Sprintf("%d rows, batch %d, throughputs %v", test.height, test.batch, test.throughputs)
+		if len(heights) != len(workers) {
+			t.Fatalf("%s: got %d heights for %d nodes", name, len(heights), len(workers))
+		}
+		total := 0
+		for _, height := range heights {
+			total += height
+			if height < test.least {
+				t.Errorf("%s: heights %v, expected at least %d rows each", name, heights, test.least)
+			}
+		}
+		if total != test.height {
+			t.Errorf("%s: heights %v add up to %d", name, heights, total)
+		}
+		for i := range heights {
+			for j := range heights {
+				// rounding can give a slower node a single row more than a faster one
+				if test.throughputs[i] > test.throughputs[j] && heights[i]+1 < heights[j] {
+					t.Errorf("%s: node %d got %d rows, fewer than the slower node %d with %d", name, i, heights[i], j, heights[j])
+				}
+			}
+		}
+	}
+
+	s, workers := balanceSession(1000, 1, 1, 4)
+	if heights := s.partition(workers); heights[1] < 3*heights[0] {
+		t.Errorf("a node four times faster only got %v of the rows", heights)
+	}
+}
+
+// TestPartitionUnmeasured checks that nodes that haven't been timed are given rows by their threads,
+// assuming they are as fast per thread as the nodes that have been timed
+func TestPartitionUnmeasured(t *testing.T) {
+	s, workers := balanceSession(300, 1, 100)
+	workers = append(workers, worker{address: "new", threads: 2})
+	s.active = workers
+	// rounding can move a row between them
+	if heights := s.partition(workers); heights[0] < 99 || heights[0] > 101 || heights[0]+heights[1] != 300 {
+		t.Errorf("got heights %v, expected the node with twice the threads to get twice the rows", heights)
+	}
+}
+
+// TestShouldRebalance checks that the world is only split up again once the interval has passed, and
+// only if the slowest node is far enough behind an even split of the work
+func TestShouldRebalance(t *testing.T) {
+	tests := []struct {
+		heights     []int
+		throughputs []float64
+		rebalance   bool
+	}{
+		{[]int{50, 50}, []float64{1, 1}, false},
+		{[]int{52, 48}, []float64{1, 1}, false}, // within the threshold
+		{[]int{75, 25}, []float64{1, 1}, true},
+		{[]int{20, 80}, []float64{1, 4}, false},
+		{[]int{50, 50}, []float64{1, 4}, true},
+		{[]int{100}, []float64{1}, false},
+	}
+	for _, test := range tests {
+		s, workers := balanceSession(100, 1, test.throughputs...)
+		s.active = workers
+		s.heights = test.heights
+		s.lastBalance = time.Now()
+		if s.shouldRebalance() {
+			t.Errorf("heights %v, throughputs %v: rebalanced before the interval passed", test.heights, test.throughputs)
+		}
+		s.lastBalance = time.Now().Add(-2 * s.g.rebalanceInterval)
+		if got := s.shouldRebalance(); got != test.rebalance {
+			t.Errorf("heights %v, throughputs %v: got %v, expected %v", test.heights, test.throughputs, got, test.rebalance)
+		}
+		// the check is only made once per interval
+		if s.shouldRebalance() {
+			t.Errorf("heights %v, throughputs %v: rebalanced twice in one interval", test.heights, test.throughputs)
+		}
+	}
+
+	s, workers := balanceSession(100, 1, 1, 1)
+	s.active, s.heights = workers, []int{90, 10}
+	s.g.rebalanceInterval = 0
+	s.lastBalance = time.Time{}
+	if s.shouldRebalance() {
+		t.Error("rebalanced with rebalancing turned off")
+	}
+}
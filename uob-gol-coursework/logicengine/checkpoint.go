@@ -0,0 +1,208 @@
+package main
+
+import (
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"uk.ac.bris.cs/gameoflife/gol"
+)
+
+// the number of old checkpoints kept in the directory alongside the latest one
+const keptCheckpoints = 2
+
+// how long to wait before trying again after a checkpoint couldn't be written
+const checkpointRetryDelay = 10 * time.Second
+
+// Everything needed to carry on a game after the logic engine restarts
+type checkpoint struct {
+	World gol.PackedWorld
+	Turn  int
+	P     gol.Params
+	Rule  string
+}
+
+// Settings for how often the logic engine saves checkpoints
+type checkpointConfig struct {
+	dir      string        // directory to write checkpoints to, checkpointing is off if empty
+	turns    int           // save a checkpoint every this many turns, 0 to not use turns
+	interval time.Duration // save a checkpoint every interval, 0 to not use time
+}
+
+// returns whether enough turns or time have passed since the last checkpoint to write another
+func (s *session) checkpointDue() bool {
+	c := s.g.checkpoints
+	if c.dir == "" || time.Now().Before(s.checkpointRetry) {
+		return false
+	}
+	if c.turns > 0 && s.currentTurn-s.lastCheckpointTurn >= c.turns {
+		return true
+	}
+	return c.interval > 0 && time.Since(s.lastCheckpointTime) >= c.interval
+}
+
+// each session keeps its checkpoints in its own directory inside the checkpoint directory
+func (s *session) checkpointDir() string {
+	return filepath.Join(s.g.checkpoints.dir, s.id)
+}
+
+// gathers the world from the nodes and saves it along with the turn and parameters. A checkpoint that
+// can't be written doesn't stop the session, its controllers are told and it is tried again shortly
+func (s *session) checkpoint() error {
+	if err := s.gather(); err != nil {
+		return err
+	}
+	err := writeCheckpoint(s.checkpointDir(), checkpoint{s.world, s.worldTurn, s.p, s.rule.String()})
+	if err != nil {
+		fmt.Println("Error writing checkpoint:", err)
+		s.checkpointRetry = time.Now().Add(checkpointRetryDelay)
+		s.updates.Publish(gol.Update{Kind: gol.Problem, Turn: s.worldTurn, Severity: gol.Warning, Operation: "writing a checkpoint", Message: err.Error()})
+		return nil
+	}
+	s.lastCheckpointTurn = s.worldTurn
+	s.lastCheckpointTime = time.Now()
+	return nil
+}
+
+func checkpointName(turn int) string {
+	return fmt.Sprintf("checkpoint-%d.gob", turn)
+}
+
+// returns the turns of all of the checkpoints in the directory, oldest first
+func checkpointTurns(dir string) []int {
+	files, _ := ioutil.ReadDir(dir)
+	turns := make([]int, 0)
+	for _, file := range files {
+		name := strings.TrimSuffix(strings.TrimPrefix(file.Name(), "checkpoint-"), ".gob")
+		if turn, err := strconv.Atoi(name); err == nil && checkpointName(turn) == file.Name() {
+			turns = append(turns, turn)
+		}
+	}
+	sort.Ints(turns)
+	return turns
+}
+
+// writes the checkpoint to a temporary file before renaming it, so that a crash mid-write never
+// leaves a broken checkpoint behind. Old checkpoints are then removed
+func writeCheckpoint(dir string, c checkpoint) error {
+	if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+		return err
+	}
+	file, err := ioutil.TempFile(dir, "checkpoint-*.tmp")
+	if err != nil {
+		return err
+	}
+	err = gob.NewEncoder(file).Encode(c)
+	if err == nil {
+		err = file.Sync()
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(file.Name(), filepath.Join(dir, checkpointName(c.Turn)))
+	}
+	if err != nil {
+		os.Remove(file.Name())
+		return err
+	}
+
+	turns := checkpointTurns(dir)
+	for len(turns) > keptCheckpoints+1 {
+		os.Remove(filepath.Join(dir, checkpointName(turns[0])))
+		turns = turns[1:]
+	}
+	return nil
+}
+
+// reads the checkpoint with the highest turn from the directory
+func readLatestCheckpoint(dir string) (c checkpoint, err error) {
+	turns := checkpointTurns(dir)
+	if len(turns) == 0 {
+		return c, errors.New("no checkpoints in " + dir)
+	}
+	file, err := os.Open(filepath.Join(dir, checkpointName(turns[len(turns)-1])))
+	if err != nil {
+		return
+	}
+	defer file.Close()
+	err = gob.NewDecoder(file).Decode(&c)
+	return
+}
+
+// replaces the session's game with the one in the checkpoint, this must be run by the start goroutine
+// unless it hasn't been started yet
+func (s *session) restore(c checkpoint, rule gol.Rule) {
+	s.p = c.P
+	s.p.Session = s.id
+	s.rule = rule
+	s.world = c.World
+	s.worldTurn = c.Turn
+	s.currentTurn = c.Turn
+	s.aliveCells = c.World.Count()
+	s.active = nil
+	s.finished = false
+	s.lastCheckpointTurn = c.Turn
+	s.lastCheckpointTime = time.Now()
+	s.updates.Publish(gol.Update{Kind: gol.TurnsCompleted, Turn: c.Turn, AliveCells: s.aliveCells})
+}
+
+// restarts the session from its latest checkpoint, replacing its game if it is still running or
+// creating the session if the logic engine doesn't have it. Replies with the turn the checkpoint was taken on
+func (g *Game) Restore(id string, turn *int) (err error) {
+	if id == "" {
+		id = defaultSession
+	}
+	if !sessionID.MatchString(id) {
+		return fmt.Errorf("no session called %q", id)
+	}
+	c, err := readLatestCheckpoint(filepath.Join(g.checkpoints.dir, id))
+	if err != nil {
+		return
+	}
+	rule, err := gol.ParseRule(c.Rule)
+	if err != nil {
+		return
+	}
+
+	s, err := g.session(id)
+	if err != nil {
+		s = g.newSession(id)
+		s.restore(c, rule)
+		s.updateStatus()
+		if err = g.addSession(s); err != nil {
+			return
+		}
+		go s.start()
+	} else if err = s.do(func() { s.restore(c, rule) }); err != nil {
+		return
+	}
+	fmt.Println("Restored session", s.id, "from turn", c.Turn)
+	*turn = c.Turn
+	return
+}
+
+// restores every session that has a directory of checkpoints
+func (g *Game) resumeAll() {
+	dirs, err := ioutil.ReadDir(g.checkpoints.dir)
+	if err != nil {
+		fmt.Println("Error resuming from checkpoints:", err)
+		return
+	}
+	for _, dir := range dirs {
+		if !dir.IsDir() || !sessionID.MatchString(dir.Name()) {
+			continue
+		}
+		var turn int
+		if err := g.Restore(dir.Name(), &turn); err != nil {
+			fmt.Println("Error resuming session", dir.Name(), "from checkpoint:", err)
+		}
+	}
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"uk.ac.bris.cs/gameoflife/gol"
+)
+
+// makes a checkpoint with a world that has the turn written into its first row
+func testCheckpoint(turn int) checkpoint {
+	world := gol.NewPackedWorld(16, 4)
+	for x := 0; x < world.Width; x++ {
+		world.Set(x, 0, turn&(1<<uint(x)) != 0)
+	}
+	world.Set(3, 2, true)
+	p := gol.Params{Turns: 1000, ImageWidth: 16, ImageHeight: 4, Rule: "B36/S23", Boundary: gol.Reflect, BatchTurns: 4, Session: "saved"}
+	return checkpoint{World: world, Turn: turn, P: p, Rule: "B36/S23"}
+}
+
+func tempDir(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "checkpoints")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return dir
+}
+
+// TestCheckpointRoundTrip checks that a checkpoint is read back with the same world, turn, params and rule
+func TestCheckpointRoundTrip(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+
+	saved := testCheckpoint(42)
+	if err := writeCheckpoint(dir, saved); err != nil {
+		t.Fatal(err)
+	}
+	read, err := readLatestCheckpoint(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(read, saved) {
+		t.Errorf("read back %+v, expected %+v", read, saved)
+	}
+	// nothing but the checkpoint should be left in the directory
+	if files, _ := ioutil.ReadDir(dir); len(files) != 1 || files[0].Name() != checkpointName(42) {
+		t.Errorf("directory has %d files after writing a checkpoint", len(files))
+	}
+}
+
+// TestKeptCheckpoints checks that only the latest few checkpoints are kept
+func TestKeptCheckpoints(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+
+	for _, turn := range []int{10, 20, 30, 40, 50} {
+		if err := writeCheckpoint(dir, testCheckpoint(turn)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if turns := checkpointTurns(dir); !reflect.DeepEqual(turns, []int{30, 40, 50}) {
+		t.Errorf("kept the checkpoints from turns %v, expected 30, 40 and 50", turns)
+	}
+}
+
+// TestRestore checks that Restore starts a session from its newest checkpoint, whatever order they were written in
+func TestRestore(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	g := startGame(t)
+	g.checkpoints.dir = dir
+
+	for _, turn := range []int{20, 30, 10} {
+		c := testCheckpoint(turn)
+		// the session finishes as soon as it is restored, so the world isn't changed by the fake worker
+		c.P.Turns = turn
+		if err := writeCheckpoint(filepath.Join(dir, "restored"), c); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var turn int
+	if err := g.Restore("restored", &turn); err != nil {
+		t.Fatal(err)
+	}
+	if turn != 30 {
+		t.Errorf("restored from turn %d, expected 30", turn)
+	}
+
+	var info gol.SessionInfo
+	if err := g.Attach("restored", &info); err != nil {
+		t.Fatal(err)
+	}
+	expected := testCheckpoint(30)
+	if info.Turn != 30 || info.P.Rule != expected.Rule || info.P.Boundary != expected.P.Boundary || info.P.Session != "restored" {
+		t.Errorf("restored session is %+v", info)
+	}
+	var wc gol.Worldcells
+	if err := g.GetWorld("restored", &wc); err != nil {
+		t.Fatal(err)
+	}
+	if wc.Turn != 30 || string(wc.World.Bits) != string(expected.World.Bits) {
+		t.Errorf("restored world is from turn %d, expected 30", turnOf(wc.World))
+	}
+}
+
+// TestCheckpointFailure checks that a checkpoint that can't be written is reported to the session's
+// controllers, and isn't counted as the last checkpoint
+func TestCheckpointFailure(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	g := startGame(t)
+	// a file where the directory should be means every checkpoint fails
+	g.checkpoints = checkpointConfig{dir: filepath.Join(dir, "file"), turns: 5}
+	if err := ioutil.WriteFile(g.checkpoints.dir, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	p := gol.Params{Turns: 20, ImageWidth: 16, ImageHeight: 4, Session: "failing"}
+	s, err := g.createSession(gol.Args{P: p, World: gol.NewPackedWorld(16, 4)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var problem *gol.Update
+	for seq, finished := 0, false; !finished; {
+		updates := s.updates.Wait(seq, 5*time.Second)
+		if len(updates) == 0 {
+			t.Fatal("timed out waiting for the session to finish")
+		}
+		for i, u := range updates {
+			seq = u.Seq
+			finished = finished || u.Kind == gol.Finished
+			if u.Kind == gol.Problem && problem == nil {
+				problem = &updates[i]
+			}
+		}
+	}
+	if problem == nil {
+		t.Fatal("the failed checkpoint wasn't reported")
+	}
+	if problem.Severity != gol.Warning || problem.Operation != "writing a checkpoint" || problem.Message == "" {
+		t.Errorf("failed checkpoint was reported as %+v", *problem)
+	}
+	s.do(func() {
+		if s.lastCheckpointTurn != 0 {
+			t.Errorf("last checkpoint is from turn %d, but none were written", s.lastCheckpointTurn)
+		}
+	})
+}
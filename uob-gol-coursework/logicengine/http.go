@@ -0,0 +1,379 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"uk.ac.bris.cs/gameoflife/gol"
+	"uk.ac.bris.cs/gameoflife/netpbm"
+)
+
+// how often an idle event stream is sent a comment, so that proxies don't close it
+const keepAliveInterval = 15 * time.Second
+
+// The HTTP/JSON API mirrors the Game rpcs for scripts and dashboards that can't speak net/rpc.
+// The requests and responses are described in API.md
+
+// Session as it is described by the HTTP API
+type sessionJSON struct {
+	ID         string `json:"id"`
+	Width      int    `json:"width"`
+	Height     int    `json:"height"`
+	Turns      int    `json:"turns"`
+	Rule       string `json:"rule"`
+	Boundary   string `json:"boundary"`
+	Turn       int    `json:"turn"`
+	AliveCells int    `json:"aliveCells"`
+	Paused     bool   `json:"paused"`
+	Running    bool   `json:"running"`
+}
+
+// Body of a request to start a session
+type startJSON struct {
+	Session    string   `json:"session"`
+	Width      int      `json:"width"`
+	Height     int      `json:"height"`
+	Turns      int      `json:"turns"`
+	Rule       string   `json:"rule"`
+	Boundary   string   `json:"boundary"`
+	BatchTurns int      `json:"batchTurns"`
+	Alive      [][2]int `json:"alive"` // the x and y of each alive cell
+}
+
+// Update as it is sent in an event stream
+type updateJSON struct {
+	Seq        int         `json:"seq"`
+	Turn       int         `json:"turn"`
+	AliveCells int         `json:"aliveCells"`
+	State      string      `json:"state,omitempty"`
+	Epoch      int         `json:"epoch,omitempty"`
+	Member     *workerJSON `json:"member,omitempty"`
+	Workers    *int        `json:"workers,omitempty"` // only on membership events, where it can be 0
+	Severity   string      `json:"severity,omitempty"`
+	Operation  string      `json:"operation,omitempty"`
+	Message    string      `json:"message,omitempty"`
+}
+
+// Node as it is described by the HTTP API
+type workerJSON struct {
+	Address   string  `json:"address"`
+	Threads   int     `json:"threads"`
+	LatencyMS float64 `json:"latencyMs"`
+	State     string  `json:"state"`
+}
+
+// Body of the reply listing the nodes
+type membershipJSON struct {
+	Epoch   int          `json:"epoch"`
+	Workers []workerJSON `json:"workers"`
+}
+
+type errorJSON struct {
+	Error string `json:"error"`
+}
+
+// the name of the event each kind of update is sent as
+var eventNames = map[gol.UpdateKind]string{
+	gol.TurnsCompleted:    "turns",
+	gol.StateChanged:      "state",
+	gol.Finished:          "finished",
+	gol.MembershipChanged: "membership",
+	gol.Problem:           "problem",
+}
+
+func toWorkerJSON(w gol.WorkerStatus) workerJSON {
+	return workerJSON{
+		Address:   w.Address,
+		Threads:   w.Threads,
+		LatencyMS: w.Latency.Seconds() * 1000,
+		State:     w.State.String(),
+	}
+}
+
+func toSessionJSON(info gol.SessionInfo) sessionJSON {
+	rule := info.P.Rule
+	if rule == "" {
+		rule = gol.Conway
+	}
+	return sessionJSON{
+		ID:         info.ID,
+		Width:      info.P.ImageWidth,
+		Height:     info.P.ImageHeight,
+		Turns:      info.P.Turns,
+		Rule:       rule,
+		Boundary:   info.P.Boundary.String(),
+		Turn:       info.Turn,
+		AliveCells: info.AliveCells,
+		Paused:     info.Paused,
+		Running:    info.Running,
+	}
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, errorJSON{err.Error()})
+}
+
+// returns the handler for the HTTP API
+func (g *Game) httpHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/sessions", g.handleSessions)
+	mux.HandleFunc("/sessions/", g.handleSession)
+	mux.HandleFunc("/workers", g.handleWorkers)
+	mux.HandleFunc("/shutdown", g.handleShutdown)
+	return g.checkToken(mux)
+}
+
+// only lets requests through if they have the logic engine's token in an Authorization: Bearer header
+func (g *Game) checkToken(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if g.security.Token != "" && subtle.ConstantTimeCompare([]byte(token), []byte(g.security.Token)) != 1 {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			writeError(w, http.StatusUnauthorized, fmt.Errorf("missing or wrong token"))
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// GET lists the sessions, POST starts one
+func (g *Game) handleSessions(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		var sessions []gol.SessionInfo
+		g.ListSessions("", &sessions)
+		list := make([]sessionJSON, 0, len(sessions))
+		for _, info := range sessions {
+			list = append(list, toSessionJSON(info))
+		}
+		writeJSON(w, http.StatusOK, list)
+	case http.MethodPost:
+		g.handleStart(w, r)
+	default:
+		writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("%s not allowed on /sessions", r.Method))
+	}
+}
+
+func (g *Game) handleStart(w http.ResponseWriter, r *http.Request) {
+	var start startJSON
+	if err := json.NewDecoder(r.Body).Decode(&start); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	if start.Width <= 0 || start.Height <= 0 {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("width and height must be positive, got %dx%d", start.Width, start.Height))
+		return
+	}
+	// the world is checked before it is made, so that a request can't make the logic engine run out of memory
+	if g.maxCells > 0 && start.Width > g.maxCells/start.Height {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("a %dx%d world has more than the %d cells allowed", start.Width, start.Height, g.maxCells))
+		return
+	}
+	boundary, err := gol.ParseBoundary(start.Boundary)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	world := gol.NewPackedWorld(start.Width, start.Height)
+	for _, cell := range start.Alive {
+		if cell[0] < 0 || cell[0] >= start.Width || cell[1] < 0 || cell[1] >= start.Height {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("alive cell %v is outside the world", cell))
+			return
+		}
+		world.Set(cell[0], cell[1], true)
+	}
+	if start.Session != "" {
+		if s, err := g.session(start.Session); err == nil && s.info().Running {
+			writeError(w, http.StatusConflict, fmt.Errorf("session %q is already running", start.Session))
+			return
+		}
+	}
+
+	p := gol.Params{
+		Turns:       start.Turns,
+		ImageWidth:  start.Width,
+		ImageHeight: start.Height,
+		BatchTurns:  start.BatchTurns,
+		Rule:        start.Rule,
+		Boundary:    boundary,
+		Session:     start.Session,
+	}
+	var id string
+	if err := g.CreateSession(gol.Args{P: p, World: world}, &id); err == errNoWorkers {
+		writeError(w, http.StatusServiceUnavailable, err)
+		return
+	} else if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	var info gol.SessionInfo
+	g.Attach(id, &info)
+	writeJSON(w, http.StatusCreated, toSessionJSON(info))
+}
+
+// handles everything under /sessions/{id}
+func (g *Game) handleSession(w http.ResponseWriter, r *http.Request) {
+	parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/sessions/"), "/")
+	if len(parts) > 2 || parts[0] == "" {
+		writeError(w, http.StatusNotFound, fmt.Errorf("no such path %s", r.URL.Path))
+		return
+	}
+	s, err := g.session(parts[0])
+	if err != nil {
+		writeError(w, http.StatusNotFound, err)
+		return
+	}
+	action := ""
+	if len(parts) == 2 {
+		action = parts[1]
+	}
+
+	switch {
+	case action == "" && r.Method == http.MethodGet:
+		writeJSON(w, http.StatusOK, toSessionJSON(s.info()))
+	case action == "" && r.Method == http.MethodDelete:
+		g.CloseSession(s.id, nil)
+		writeJSON(w, http.StatusOK, toSessionJSON(s.info()))
+	case action == "pause" && r.Method == http.MethodPost:
+		var turn int
+		if err := g.Pause(s.id, &turn); err != nil {
+			writeError(w, http.StatusConflict, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, toSessionJSON(s.info()))
+	case action == "resume" && r.Method == http.MethodPost:
+		if err := g.Resume(s.id, nil); err != nil {
+			writeError(w, http.StatusConflict, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, toSessionJSON(s.info()))
+	case action == "world" && r.Method == http.MethodGet:
+		g.handleWorld(w, s)
+	case action == "events" && r.Method == http.MethodGet:
+		g.handleEvents(w, r, s)
+	default:
+		writeError(w, http.StatusNotFound, fmt.Errorf("no such path %s %s", r.Method, r.URL.Path))
+	}
+}
+
+// sends the session's latest world as a binary PGM image
+func (g *Game) handleWorld(w http.ResponseWriter, s *session) {
+	var wc gol.Worldcells
+	if err := g.GetWorld(s.id, &wc); err != nil {
+		writeError(w, http.StatusConflict, err)
+		return
+	}
+	w.Header().Set("Content-Type", "image/x-portable-graymap")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"%dx%dx%d.pgm\"", wc.World.Width, wc.World.Height, wc.Turn))
+	w.Header().Set("X-Turn", strconv.Itoa(wc.Turn))
+	netpbm.Encode(w, netpbm.FromCells(netpbm.PGM, wc.World.Unpack()))
+}
+
+// streams the session's updates as server-sent events until it finishes or the client goes away. A client
+// that reconnects with Last-Event-ID, or asks for ?after=seq, only gets the updates it hasn't seen
+func (g *Game) handleEvents(w http.ResponseWriter, r *http.Request, s *session) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeError(w, http.StatusInternalServerError, fmt.Errorf("streaming isn't supported"))
+		return
+	}
+	after := r.Header.Get("Last-Event-ID")
+	if after == "" {
+		after = r.URL.Query().Get("after")
+	}
+	seq, _ := strconv.Atoi(after)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	for {
+		updates := s.updates.Wait(seq, keepAliveInterval)
+		select {
+		case <-r.Context().Done():
+			return
+		default:
+		}
+		if len(updates) == 0 {
+			fmt.Fprint(w, ": keep-alive\n\n")
+			flusher.Flush()
+			continue
+		}
+		for _, u := range updates {
+			seq = u.Seq
+			data := updateJSON{Seq: u.Seq, Turn: u.Turn, AliveCells: u.AliveCells}
+			if u.Kind == gol.StateChanged {
+				data.State = u.State.String()
+			}
+			if u.Kind == gol.MembershipChanged {
+				member := toWorkerJSON(u.Member)
+				data.Epoch = u.Epoch
+				data.Member = &member
+				data.Workers = &u.Workers
+			}
+			if u.Kind == gol.Problem {
+				data.Severity = u.Severity.String()
+				data.Operation = u.Operation
+				data.Message = u.Message
+			}
+			encoded, _ := json.Marshal(data)
+			fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", u.Seq, eventNames[u.Kind], encoded)
+			if u.Kind == gol.Finished {
+				flusher.Flush()
+				return
+			}
+		}
+		flusher.Flush()
+	}
+}
+
+// lists the nodes and the membership epoch
+func (g *Game) handleWorkers(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("%s not allowed on /workers", r.Method))
+		return
+	}
+	var membership gol.Membership
+	g.ListWorkers("", &membership)
+	list := membershipJSON{Epoch: membership.Epoch, Workers: make([]workerJSON, 0, len(membership.Workers))}
+	for _, status := range membership.Workers {
+		list.Workers = append(list.Workers, toWorkerJSON(status))
+	}
+	writeJSON(w, http.StatusOK, list)
+}
+
+// shuts down the logic engine and its nodes, after replying
+func (g *Game) handleShutdown(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("%s not allowed on /shutdown", r.Method))
+		return
+	}
+	writeJSON(w, http.StatusAccepted, struct{}{})
+	w.(http.Flusher).Flush()
+	go g.Shutdown("", nil)
+}
+
+// serves the HTTP API on the address, over TLS if the rpcs are
+func serveHTTP(address string, g *Game) {
+	listener, err := g.security.Listen(address)
+	if err != nil {
+		fmt.Println("Error serving HTTP API:", err)
+		return
+	}
+	fmt.Println("serving HTTP API on", address)
+	if err := http.Serve(listener, g.httpHandler()); err != nil {
+		fmt.Println("Error serving HTTP API:", err)
+	}
+}
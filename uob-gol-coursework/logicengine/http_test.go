@@ -0,0 +1,132 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"uk.ac.bris.cs/gameoflife/gol"
+)
+
+// request makes a request to the HTTP API and decodes the JSON response into v, checking the status code
+func request(t *testing.T, method, url, body string, status int, v interface{}) {
+	req, err := http.NewRequest(method, url, strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	if res.StatusCode != status {
+		msg, _ := ioutil.ReadAll(res.Body)
+		t.Fatalf("%s %s: got status %d, expected %d: %s", method, url, res.StatusCode, status, msg)
+	}
+	if v != nil {
+		if err := json.NewDecoder(res.Body).Decode(v); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// TestHTTP starts a session through the HTTP API, pauses it, downloads its world and follows its events to the end
+func TestHTTP(t *testing.T) {
+	g := startGame(t)
+	server := httptest.NewServer(g.httpHandler())
+	defer server.Close()
+
+	var started sessionJSON
+	request(t, "POST", server.URL+"/sessions", `{"session": "http", "width": 16, "height": 4, "turns": 2000, "alive": [[1, 2], [3, 3]]}`, http.StatusCreated, &started)
+	if started.ID != "http" || started.Width != 16 || started.Turns != 2000 || started.Boundary != "torus" || !started.Running {
+		t.Errorf("unexpected session %+v", started)
+	}
+	request(t, "POST", server.URL+"/sessions", `{"session": "http", "width": 16, "height": 4, "turns": 10}`, http.StatusConflict, nil)
+	request(t, "POST", server.URL+"/sessions", `{"width": 16, "height": 4, "alive": [[16, 0]]}`, http.StatusBadRequest, nil)
+	request(t, "POST", server.URL+"/sessions", `{"width": 0, "height": 4}`, http.StatusBadRequest, nil)
+	request(t, "POST", server.URL+"/sessions", `{"width": 16, "height": -4}`, http.StatusBadRequest, nil)
+	g.maxCells = 1 << 20
+	request(t, "POST", server.URL+"/sessions", `{"width": 2000000000, "height": 2000000000}`, http.StatusBadRequest, nil)
+	request(t, "POST", server.URL+"/sessions", `{"width": 1025, "height": 1024}`, http.StatusBadRequest, nil)
+	request(t, "GET", server.URL+"/sessions/missing", "", http.StatusNotFound, nil)
+
+	var paused, status sessionJSON
+	request(t, "POST", server.URL+"/sessions/http/pause", "", http.StatusOK, &paused)
+	request(t, "GET", server.URL+"/sessions/http", "", http.StatusOK, &status)
+	if !status.Paused || status.Turn != paused.Turn {
+		t.Errorf("session on turn %d after pausing on turn %d, paused: %v", status.Turn, paused.Turn, status.Paused)
+	}
+
+	res, err := http.Get(server.URL + "/sessions/http/world")
+	if err != nil {
+		t.Fatal(err)
+	}
+	image, _ := ioutil.ReadAll(res.Body)
+	res.Body.Close()
+	header := "P5\n16 4\n255\n"
+	if !bytes.HasPrefix(image, []byte(header)) || len(image) != len(header)+16*4 {
+		t.Fatalf("world isn't a 16x4 PGM: %q", image)
+	}
+	if turn := turnOf(pgmRow(image[len(header):])); fmt.Sprint(turn) != res.Header.Get("X-Turn") || turn != paused.Turn {
+		t.Errorf("world from turn %d reported as turn %s while paused on turn %d", turn, res.Header.Get("X-Turn"), paused.Turn)
+	}
+
+	var sessions []sessionJSON
+	request(t, "GET", server.URL+"/sessions", "", http.StatusOK, &sessions)
+	if len(sessions) != 1 || sessions[0].ID != "http" {
+		t.Errorf("unexpected sessions %+v", sessions)
+	}
+
+	res, err = http.Get(server.URL + "/sessions/http/events")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	if res.Header.Get("Content-Type") != "text/event-stream" {
+		t.Fatalf("events sent as %s", res.Header.Get("Content-Type"))
+	}
+	request(t, "POST", server.URL+"/sessions/http/resume", "", http.StatusOK, nil)
+
+	// the stream ends once the session finishes
+	var events []string
+	var last updateJSON
+	scanner := bufio.NewScanner(res.Body)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if strings.HasPrefix(line, "event: ") {
+			events = append(events, strings.TrimPrefix(line, "event: "))
+		} else if strings.HasPrefix(line, "data: ") {
+			if err := json.Unmarshal([]byte(strings.TrimPrefix(line, "data: ")), &last); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	if len(events) == 0 || events[len(events)-1] != "finished" || last.Turn != 2000 {
+		t.Errorf("stream ended with %v on turn %d, expected finished on turn 2000", events, last.Turn)
+	}
+	found := false
+	for _, event := range events {
+		found = found || event == "state"
+	}
+	if !found {
+		t.Errorf("no state event in %v", events)
+	}
+
+	request(t, "DELETE", server.URL+"/sessions/http", "", http.StatusOK, nil)
+	request(t, "GET", server.URL+"/sessions/http", "", http.StatusNotFound, nil)
+}
+
+// reads the first row of a 16 cell wide PGM back into a world
+func pgmRow(cells []byte) (world gol.PackedWorld) {
+	world = gol.NewPackedWorld(16, 1)
+	for x := 0; x < 16; x++ {
+		world.Set(x, 0, cells[x] == 255)
+	}
+	return
+}
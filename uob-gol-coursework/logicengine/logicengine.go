@@ -3,116 +3,346 @@ package main
 import (
 	"flag"
 	"fmt"
-	"net"
 	"net/rpc"
+	"os"
 	"sync"
+	"time"
 
 	"uk.ac.bris.cs/gameoflife/gol"
 )
 
 type Game struct {
-	currentlyRunning bool
-	world            [][]byte
-	currentTurn      int
-	p                gol.Params
-	quit             bool
-	pausechannel     chan bool
-	paused           bool
-	workers          []*rpc.Client
-	shutdownChannel  chan bool
-}
-
-// Sends world to worker and updates output slice with the result, if an error is returned by the remote procedure call then
-// the problem variable is set to true to indicate the turn needs to be recomputed and the client needs to be removed from
-// the logic engine's list of nodes
-func (g *Game) getNewState(client *rpc.Client, contextWorld [][]byte, output [][]byte, wg *sync.WaitGroup, problem *bool) {
-	err := client.Call("Worker.NextState", contextWorld, &output)
-	if err != nil {
-		*problem = true
-		wg.Done()
-		return
+	sessions        map[string]*session // every simulation hosted by the logic engine, by ID
+	sessionsLock    sync.Mutex          // held while changing the sessions
+	workers         []worker            // every node subscribed to the logic engine, shared by all sessions
+	workersLock     sync.Mutex          // held while changing the list of workers
+	joined          chan bool           // closed and replaced when a node subscribes
+	epoch           int                 // membership epoch, increased every time a node joins or leaves
+	membership      gol.UpdateLog       // the membership changes still to be passed on to the sessions
+	peers           []gol.WorkerInfo    // nodes from the peer file, which the logic engine connects to itself
+	batchTurns      int                 // the number of turns to batch when the controller doesn't choose
+	deadline        time.Duration       // how long to wait for a node to reply before dropping it, on top of the time allowed for its work
+	shutdownChannel chan bool
+
+	throughput        map[string]float64 // average cell-turns per second calculated by each node
+	throughputLock    sync.Mutex         // held while reading or updating the throughputs
+	rebalanceInterval time.Duration      // how often to check whether the strips need resizing
+
+	checkpoints checkpointConfig
+	security    gol.Security // how connections to and from the logic engine are protected
+	maxCells    int          // the most cells a world started over the HTTP API can have, 0 for no limit
+}
+
+// Splits the last gathered world between all of the subscribed nodes. Each node keeps its strip
+// and only swaps edge rows with its neighbours, so the world doesn't need to be sent again until
+// the nodes change
+func (s *session) distribute() error {
+	workers, epoch := s.g.activeWorkers()
+	num_workers := len(workers)
+	if num_workers == 0 {
+		s.active = nil
+		return errNoWorkers
+	}
+	s.members = epoch
+	if num_workers > s.p.ImageHeight {
+		num_workers = s.p.ImageHeight
+	}
+	s.epoch++
+	s.active = workers[:num_workers]
+	s.heights = s.partition(s.active)
+	fmt.Println("Splitting rows of session", s.id, "between workers:", s.heights)
+	s.lastBalance = time.Now()
+
+	var wg sync.WaitGroup
+	wg.Add(num_workers)
+	errs := make([]error, num_workers)
+	currentBottom := 0
+	for num, w := range s.active {
+		nextBottom := currentBottom + s.heights[num]
+		args := gol.StripArgs{
+			Session: s.id,
+			Epoch:   s.epoch,
+			Strip:   s.world.Rows(currentBottom, nextBottom),
+			Rule:    s.rule,
+			Above:   s.active[gol.Mod(num-1, num_workers)].address,
+			Below:   s.active[gol.Mod(num+1, num_workers)].address,
+
+			Boundary: s.p.Boundary,
+		}
+		// the strips on the top and bottom of the world get their outer halos from the boundary
+		if num == 0 {
+			args.AboveEdge = s.p.Boundary.VerticalEdge()
+		}
+		if num == num_workers-1 {
+			args.BelowEdge = s.p.Boundary.VerticalEdge()
+		}
+		go func(num int, w worker) {
+			errs[num] = s.g.callWithin(w, "Worker.Init", args, nil, s.g.deadline+transferAllowance(args.Strip.Width*args.Strip.Height))
+			wg.Done()
+		}(num, w)
+		currentBottom = nextBottom
 	}
-	wg.Done()
+	wg.Wait()
+	s.currentTurn = s.worldTurn
+	if err := s.dropFailed(errs); err != nil {
+		return err
+	}
+	// the nodes report the cells that flip from the strips they have just been given
+	s.frames.Next(s.world, s.worldTurn)
+	return nil
 }
 
-// The actual processing of the world
-func (g *Game) start() {
-	for g.currentTurn = 0; g.currentTurn < g.p.Turns; g.currentTurn++ {
-		if g.paused {
-			<-g.pausechannel
-			g.paused = false
+// Removes the nodes that failed from the list of workers, returning an error if any failed
+func (s *session) dropFailed(errs []error) error {
+	var err error
+	for i, e := range errs {
+		if e == nil {
+			continue
 		}
+		err = e
+		// a node reporting a problem with one of its neighbours is still alive
+		if _, ok := e.(rpc.ServerError); ok {
+			continue
+		}
+		s.g.removeWorker(s.active[i], e)
+	}
+	if err != nil {
+		s.active = nil
+		s.currentTurn = s.worldTurn
+	}
+	return err
+}
 
-		var wg sync.WaitGroup
+// Gets every node to calculate the next turn of its strip, if an error is returned by any of the remote
+// procedure calls then the nodes that failed are removed and the world needs to be redistributed
+func (s *session) step() error {
+	var wg sync.WaitGroup
+	wg.Add(len(s.active))
+	errs := make([]error, len(s.active))
+	replies := make([]gol.StepReply, len(s.active))
+	// nodes give up waiting for halos before the deadline so that only a node that has stopped
+	// responding misses it, rather than its neighbours as well
+	args := gol.StepArgs{Session: s.id, Epoch: s.epoch, Turn: s.currentTurn, Turns: s.turnsPerStep(), HaloTimeout: s.g.deadline / 2}
+	for num, w := range s.active {
+		// a node is given longer to reply the more of the world it has to calculate
+		work := s.heights[num] * s.p.ImageWidth * args.Turns
+		go func(num int, w worker) {
+			errs[num] = s.g.callWithin(w, "Worker.Step", args, &replies[num], s.g.deadline+s.g.computeAllowance(w, work))
+			wg.Done()
+		}(num, w)
+	}
+	wg.Wait() // wait for all the nodes to finish computing
+	if err := s.dropFailed(errs); err != nil {
+		return err
+	}
 
-		currentBottom := 0
-		num_workers := len(g.workers)
-		out := make([][][]byte, num_workers)
-		wg.Add(num_workers)
+	s.aliveCells = 0
+	for _, reply := range replies {
+		s.aliveCells += reply.AliveCells
+	}
+	s.recordStep(replies, args.Turns)
+	s.currentTurn += args.Turns
+	s.updates.Publish(gol.Update{Kind: gol.TurnsCompleted, Turn: s.currentTurn, AliveCells: s.aliveCells})
+	return nil
+}
 
-		problem_slice := make([]bool, num_workers)
-		var problem bool
+// the number of turns the controller asked to be batched, or the logic engine's default if it didn't say
+func (s *session) batchTurns() int {
+	if s.p.BatchTurns > 0 {
+		return s.p.BatchTurns
+	}
+	return s.g.batchTurns
+}
 
-		for num, client := range g.workers {
-			nextBottom := currentBottom + (g.p.ImageHeight / num_workers)
-			if num == num_workers-1 {
-				nextBottom = g.p.ImageHeight
-			}
-			out[num] = make([][]byte, nextBottom-currentBottom)
-			for i := range out[num] {
-				out[num][i] = make([]byte, g.p.ImageWidth)
-			}
+// the number of turns the nodes should calculate before swapping halos, this can't be more than the
+// height of the smallest strip or the nodes would need rows from beyond their neighbours
+func (s *session) turnsPerStep() int {
+	turns := s.batchTurns()
+	for _, height := range s.heights {
+		if turns > height {
+			turns = height
+		}
+	}
+	if remaining := s.p.Turns - s.currentTurn; turns > remaining {
+		turns = remaining
+	}
+	// the nodes stop on turns that are being recorded so the world can be gathered
+	if next := s.p.Record.Next(s.currentTurn, s.p.Turns); next >= 0 && turns > next-s.currentTurn {
+		turns = next - s.currentTurn
+	}
+	if turns < 1 {
+		turns = 1
+	}
+	return turns
+}
+
+// Collects the strips from the nodes so that s.world is the world at the current turn
+func (s *session) gather() error {
+	if s.active == nil || s.worldTurn == s.currentTurn {
+		return nil
+	}
+	strips := make([]gol.PackedWorld, len(s.active))
+	errs := make([]error, len(s.active))
+	var wg sync.WaitGroup
+	wg.Add(len(s.active))
+	for num, w := range s.active {
+		cells := s.heights[num] * s.p.ImageWidth
+		go func(num int, w worker) {
+			errs[num] = s.g.callWithin(w, "Worker.GetStrip", s.id, &strips[num], s.g.deadline+transferAllowance(cells))
+			wg.Done()
+		}(num, w)
+	}
+	wg.Wait()
+	if err := s.dropFailed(errs); err != nil {
+		return err
+	}
+
+	s.world = gol.JoinRows(strips...)
+	s.worldTurn = s.currentTurn
+	return nil
+}
+
+// Collects the cells that have flipped on each node since they were last collected, so that the newest
+// display frame is the world at the current turn without the whole world being gathered
+func (s *session) collectFlips() error {
+	if s.active == nil {
+		s.frames.Next(s.world, s.worldTurn)
+		return nil
+	}
+	if s.frames.Turn() == s.currentTurn {
+		return nil
+	}
+	strips := make([]gol.Flips, len(s.active))
+	errs := make([]error, len(s.active))
+	var wg sync.WaitGroup
+	wg.Add(len(s.active))
+	for num, w := range s.active {
+		cells := s.heights[num] * s.p.ImageWidth
+		go func(num int, w worker) {
+			errs[num] = s.g.callWithin(w, "Worker.GetFlips", s.id, &strips[num], s.g.deadline+transferAllowance(cells))
+			wg.Done()
+		}(num, w)
+	}
+	wg.Wait()
+	if err := s.dropFailed(errs); err != nil {
+		return err
+	}
 
-			// contexted world includes overlapping rows above and below
-			previousRow := gol.Mod(currentBottom-1, g.p.ImageHeight)
-			var contextedWorld [][]byte = append(g.world[previousRow:previousRow+1], g.world[currentBottom:nextBottom]...)
-			contextedWorld = append(contextedWorld, g.world[gol.Mod(nextBottom, g.p.ImageHeight)])
+	flips := gol.JoinFlips(strips...)
+	flips.From = s.frames.Turn()
+	flips.Turn = s.currentTurn
+	s.frames.Add(flips)
+	return nil
+}
 
-			go g.getNewState(client, contextedWorld, out[num], &wg, &problem_slice[num])
+// gathers the world on a turn that is being recorded and keeps it for the controller to collect
+func (s *session) record() error {
+	if err := s.gather(); err != nil {
+		return err
+	}
+	s.recorded.Add(gol.Worldcells{World: s.world, Turn: s.worldTurn})
+	return nil
+}
 
-			currentBottom = nextBottom
+// returns whether the node has a strip of the session
+func (s *session) uses(w worker) bool {
+	for _, active := range s.active {
+		if active.address == w.address {
+			return true
 		}
+	}
+	return false
+}
 
-		newWorld := make([][]byte, 0)
-
-		wg.Wait() // wait for all the nodes to finish computing
-		for i := range problem_slice {
-			if problem_slice[i] {
-				newClients := make([]*rpc.Client, 0)
-				for j := range g.workers {
-					if g.workers[j] != g.workers[i] {
-						newClients = append(newClients, g.workers[j])
-					}
-				}
-				g.workers = newClients
-				problem = true
+// The actual processing of the world. Commands from the rpcs are carried out between turns, and
+// while the session is paused or finished the goroutine just waits for commands
+func (s *session) start() {
+	for !s.closed {
+		switch {
+		case s.paused || s.finished:
+			s.carryOut(<-s.commands)
+			continue
+		case s.waiting:
+			// nothing can be done until a node subscribes
+			joined, subscribed := s.g.joinedSignal()
+			if subscribed {
+				s.waiting = false
+				continue
+			}
+			select {
+			case c := <-s.commands:
+				s.carryOut(c)
+			case <-joined:
+				s.waiting = false
 			}
+			continue
 		}
-		if problem { // restart the turn if an error occurs in the remote procedure call to the nodes
-			g.currentTurn--
+		select {
+		case c := <-s.commands:
+			s.carryOut(c)
 			continue
+		default:
 		}
 
-		for i := range g.workers {
-			newWorld = append(newWorld, out[i]...)
+		var err error
+		switch {
+		case s.active == nil:
+			err = s.distribute()
+		case s.members != s.g.membershipEpoch() || s.shouldRebalance():
+			// the world has to be split up again whenever a node joins or leaves, or when some nodes
+			// are turning out to be faster than others
+			if err = s.gather(); err == nil {
+				err = s.distribute()
+			}
+		case s.currentTurn < s.p.Turns:
+			err = s.step()
+			if err == nil && s.p.Record.Wants(s.currentTurn, s.p.Turns) {
+				err = s.record()
+			}
+			if err == nil && s.checkpointDue() {
+				err = s.checkpoint()
+			}
+		default:
+			if err = s.gather(); err == nil {
+				s.finish()
+			}
+		}
+		s.updateStatus()
+
+		if err == errNoWorkers {
+			fmt.Println("Error on turn", s.currentTurn, err)
+			fmt.Println("Waiting for a worker node to subscribe")
+			s.waiting = true
+		} else if err != nil {
+			// if a node has failed then the turns since the world was last gathered are recomputed
+			fmt.Println("Error on turn", s.currentTurn, err)
+			time.Sleep(100 * time.Millisecond)
 		}
-		g.world = newWorld
 	}
-	g.currentlyRunning = false
-	return
+	s.dropStrips()
+	if !s.finished {
+		s.updates.Publish(gol.Update{Kind: gol.Finished, Turn: s.currentTurn, AliveCells: s.aliveCells})
+	}
+	s.updateStatus()
+	close(s.done)
 }
 
-// The function ran by the controller in order to start the processing
+// called once the world has been gathered after the last turn
+func (s *session) finish() {
+	s.finished = true
+	// the nodes don't need their strips any more
+	s.dropStrips()
+	s.updates.Publish(gol.Update{Kind: gol.Finished, Turn: s.worldTurn, AliveCells: s.aliveCells})
+}
+
+// The function ran by the controller in order to start the processing. If the session named in
+// the params is already running the controller is attached to it instead
 func (g *Game) Evolve(a gol.Args, reply *string) (err error) {
-	if g.currentlyRunning {
+	if s, err := g.session(a.P.Session); err == nil && s.info().Running {
 		*reply = "already running"
-		return
+		return nil
 	}
-	g.currentlyRunning = true
-	g.p = a.P
-	g.world = gol.CalculateWorld(a.Alive, g.p.ImageHeight, g.p.ImageWidth)
-
-	go g.start()
+	_, err = g.createSession(a)
 	return
 }
 
@@ -120,75 +350,132 @@ const alive = 255
 const dead = 0
 
 // returns the current turn to the controller
-func (g *Game) CurrentTurn(str string, turn *int) (err error) {
-	*turn = g.currentTurn
+func (g *Game) CurrentTurn(id string, turn *int) (err error) {
+	s, err := g.session(id)
+	if err != nil {
+		return
+	}
+	*turn = s.info().Turn
 	return
 }
 
-// pauses the start goroutine and sends the controller the current turn
-func (g *Game) Pause(str string, turn *int) (err error) {
-	fmt.Println("PAUSING")
-	g.paused = true
-	*turn = g.currentTurn
-	return
+// pauses the session once the turn being calculated is finished and sends the controller that turn
+func (g *Game) Pause(id string, turn *int) (err error) {
+	s, err := g.session(id)
+	if err != nil {
+		return
+	}
+	return s.do(func() {
+		fmt.Println("PAUSING", s.id)
+		s.paused = true
+		*turn = s.currentTurn
+		s.updates.Publish(gol.Update{Kind: gol.StateChanged, Turn: s.currentTurn, State: gol.Paused})
+	})
 }
 
-// resumes the start goroutine
-func (g *Game) Resume(str string, msg *string) (err error) {
-	fmt.Println("RESUMING")
-	g.pausechannel <- true
-	return
+// resumes the session
+func (g *Game) Resume(id string, msg *string) (err error) {
+	s, err := g.session(id)
+	if err != nil {
+		return
+	}
+	return s.do(func() {
+		fmt.Println("RESUMING", s.id)
+		s.paused = false
+		s.updates.Publish(gol.Update{Kind: gol.StateChanged, Turn: s.currentTurn, State: gol.Executing})
+	})
 }
 
 // used by the controller to detect if connecting to an already paused process
-func (g *Game) IsPaused(str string, paused *bool) (err error) {
-	*paused = g.paused
+func (g *Game) IsPaused(id string, paused *bool) (err error) {
+	s, err := g.session(id)
+	if err != nil {
+		return
+	}
+	*paused = s.info().Paused
 	return
 }
 
-// returns the world and current turn to the controller
-func (g *Game) GetWorld(str string, wc *gol.Worldcells) (err error) {
-	*wc = gol.Worldcells{g.world, g.currentTurn}
-	return
+// gathers the world from the nodes between turns and returns it and the turn it is from to the controller
+func (g *Game) GetWorld(id string, wc *gol.Worldcells) (err error) {
+	s, err := g.session(id)
+	if err != nil {
+		return
+	}
+	return s.do(func() {
+		if err := s.gather(); err != nil {
+			fmt.Println("Error gathering world:", err)
+		}
+		*wc = gol.Worldcells{World: s.world, Turn: s.worldTurn}
+	})
 }
 
-// returns the current turn and number of alive cells to the controller
-// used for AliveCellCount events
-func (g *Game) GetTurncells(str string, tc *gol.Turncells) (err error) {
-	turn := g.currentTurn
-	world := make([][]byte, g.p.ImageHeight)
-	for i := range world {
-		world[i] = make([]byte, g.p.ImageWidth)
+// collects the cells that have flipped on the nodes and returns the cells that have flipped since the turn
+// the controller is displaying. If that turn is too old then every alive cell is returned, flipped from an empty world
+func (g *Game) GetFlips(args gol.FlipsArgs, flips *gol.Flips) (err error) {
+	s, err := g.session(args.Session)
+	if err != nil {
+		return
 	}
+	return s.do(func() {
+		if err := s.collectFlips(); err != nil {
+			fmt.Println("Error collecting flipped cells:", err)
+		}
+		*flips = s.frames.Since(args.Since)
+	})
+}
 
-	copy(world, g.world)
-	*tc = gol.Turncells{turn, len(gol.CalculateAliveCells(world))}
+// returns the frames recorded after the turn the controller has already collected
+func (g *Game) GetRecorded(args gol.RecordedArgs, frames *[]gol.Worldcells) (err error) {
+	s, err := g.session(args.Session)
+	if err != nil {
+		return
+	}
+	return s.do(func() {
+		*frames = s.recorded.After(args.After)
+	})
+}
+
+// returns the current turn and number of alive cells to the controller
+// used for AliveCell events
+func (g *Game) GetTurncells(id string, tc *gol.Turncells) (err error) {
+	s, err := g.session(id)
+	if err != nil {
+		return
+	}
+	info := s.info()
+	*tc = gol.Turncells{Turn: info.Turn, Num_cells: info.AliveCells}
 	return
 }
 
 // used by the controller to detect when processing has finisehd
-func (g *Game) IsFinished(str string, done *bool) (err error) {
-	*done = !g.currentlyRunning
+func (g *Game) IsFinished(id string, done *bool) (err error) {
+	s, err := g.session(id)
+	if err != nil {
+		return
+	}
+	*done = !s.info().Running
 	return
 }
 
 // called by the nodes to give the
-func (g *Game) Subscribe(address string, reply *string) (err error) {
-	fmt.Println("Worker Request from ", address)
-	client, err := rpc.Dial("tcp", address)
-	if err != nil {
-		fmt.Println("Error subscribing ", address)
+func (g *Game) Subscribe(info gol.WorkerInfo, reply *string) (err error) {
+	fmt.Println("Worker Request from ", info.Address, "with", info.Threads, "threads")
+	if err = g.addWorker(info); err != nil {
+		fmt.Println("Error subscribing ", info.Address)
 		fmt.Println(err)
-		return
 	}
-	g.workers = append(g.workers, client)
 	return
 }
 
-// closes each worker before shutting down the logic engine
+// stops every session between turns, then closes each worker before shutting down the logic engine
 func (g *Game) Shutdown(msg string, reply *string) (err error) {
-	for _, v := range g.workers {
-		v.Call("Worker.Shutdown", "", nil)
+	// the sessions are closed without holding the sessions lock, as closing waits on every node
+	for _, s := range g.sessionList() {
+		s.close()
+	}
+	for _, v := range g.workerList() {
+		g.call(v, "Worker.Shutdown", "", nil)
 	}
 	g.shutdownChannel <- true
 	return
@@ -197,24 +484,66 @@ func (g *Game) Shutdown(msg string, reply *string) (err error) {
 // registers the functions to rpc, creates the listener and accepts incomming connections from both the controller and nodes
 func AcceptConnections(pAddr string, g *Game) {
 	rpc.Register(g)
-	listener, err := net.Listen("tcp", ":"+pAddr)
+	listener, err := g.security.Listen(":" + pAddr)
 	fmt.Println("created listener")
 	if err != nil {
 		panic(err)
 	}
-	rpc.Accept(listener)
+	g.security.Accept(listener, rpc.DefaultServer)
 	listener.Close()
 }
 
 func main() {
 	pAddr := flag.String("port", "8030", "port to listen on")
-	pauseChannel := make(chan bool)
+	batchTurns := flag.Int("batch", 1, "number of turns the nodes calculate between halo exchanges, unless set by the controller")
+	var checkpoints checkpointConfig
+	flag.StringVar(&checkpoints.dir, "checkpoints", "", "directory to save checkpoints to, no checkpoints are saved if empty")
+	flag.IntVar(&checkpoints.turns, "checkpoint-turns", 0, "save a checkpoint every this many turns")
+	flag.DurationVar(&checkpoints.interval, "checkpoint-interval", 5*time.Minute, "save a checkpoint this often")
+	resume := flag.Bool("resume", false, "carry on from the latest checkpoint in the checkpoint directory")
+	deadline := flag.Duration("deadline", 10*time.Second, "how long to wait for a node to reply before dropping it, on top of the time allowed for the work in the call")
+	heartbeat := flag.Duration("heartbeat", 2*time.Second, "how often to check that the nodes are still responding")
+	rebalance := flag.Duration("rebalance", 5*time.Second, "how often to check whether faster nodes should get more rows, 0 to never resize strips")
+	var security gol.Security
+	security.Flags(flag.CommandLine)
+	peerFile := flag.String("peers", "", "file listing nodes to connect to, one address and optional number of threads per line")
+	httpAddr := flag.String("http", "", "address to serve the HTTP/JSON API on, e.g. :8080, no HTTP API is served if empty")
+	maxCells := flag.Int("max-cells", gol.MaxCells, "the most cells a session started over the HTTP API can have, 0 for no limit")
 	shutdownChannel := make(chan bool)
 	flag.Parse()
 
 	// create an initial game struct
-	game := &Game{false, nil, 0, gol.Params{}, false, pauseChannel, false, []*rpc.Client{}, shutdownChannel}
+	game := &Game{
+		sessions:          make(map[string]*session),
+		shutdownChannel:   shutdownChannel,
+		joined:            make(chan bool),
+		batchTurns:        *batchTurns,
+		deadline:          *deadline,
+		throughput:        make(map[string]float64),
+		rebalanceInterval: *rebalance,
+		checkpoints:       checkpoints,
+		security:          security,
+		maxCells:          *maxCells,
+	}
+	if *peerFile != "" {
+		peers, err := readPeers(*peerFile)
+		if err != nil {
+			fmt.Println("Error reading peer file:", err)
+			os.Exit(1)
+		}
+		game.peers = peers
+		game.connectPeers()
+	}
+	go game.announceMembership()
+	go game.heartbeat(*heartbeat)
+
+	if *resume {
+		game.resumeAll()
+	}
 
 	go AcceptConnections(*pAddr, game)
+	if *httpAddr != "" {
+		go serveHTTP(*httpAddr, game)
+	}
 	<-shutdownChannel
 }
@@ -0,0 +1,348 @@
+package main
+
+import (
+	"fmt"
+	"math/bits"
+	"net"
+	"net/rpc"
+	"sync"
+	"testing"
+	"time"
+
+	"uk.ac.bris.cs/gameoflife/gol"
+)
+
+// fakeWorker stands in for a node. Instead of calculating turns it writes the turn it is on into the
+// first row of its strip, so that a world can be checked against the turn it is reported with
+type fakeWorker struct {
+	lock  sync.Mutex
+	strip gol.PackedWorld
+	shown gol.PackedWorld // the strip as it was when its flips were last reported
+	turn  int
+}
+
+func (f *fakeWorker) Init(args gol.StripArgs, reply *bool) error {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	f.strip = args.Strip
+	f.shown = args.Strip
+	f.turn = turnOf(args.Strip)
+	return nil
+}
+
+func (f *fakeWorker) Step(args gol.StepArgs, reply *gol.StepReply) error {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	f.turn = args.Turn + args.Turns
+	strip := gol.NewPackedWorld(f.strip.Width, f.strip.Height)
+	for x := 0; x < strip.Width; x++ {
+		strip.Set(x, 0, f.turn&(1<<uint(x)) != 0)
+	}
+	f.strip = strip
+	reply.AliveCells = bits.OnesCount(uint(f.turn))
+	return nil
+}
+
+func (f *fakeWorker) GetStrip(session string, strip *gol.PackedWorld) error {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	*strip = f.strip
+	return nil
+}
+
+func (f *fakeWorker) GetFlips(session string, flips *gol.Flips) error {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	*flips = gol.Diff(f.shown, f.strip)
+	f.shown = f.strip
+	return nil
+}
+
+func (f *fakeWorker) Drop(session string, reply *bool) error { return nil }
+
+func (f *fakeWorker) Ping(msg string, reply *bool) error { return nil }
+
+// reads back the turn written by the fake worker
+func turnOf(world gol.PackedWorld) int {
+	turn := 0
+	for x := 0; x < world.Width; x++ {
+		if world.Alive(x, 0) {
+			turn |= 1 << uint(x)
+		}
+	}
+	return turn
+}
+
+// startFakeWorker starts a fake worker listening on a free port, returning its address
+func startFakeWorker(t *testing.T) string {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := rpc.NewServer()
+	server.RegisterName("Worker", &fakeWorker{})
+	go server.Accept(listener)
+	return listener.Addr().String()
+}
+
+// startGame creates a logic engine with a single fake worker subscribed to it
+func startGame(t *testing.T) *Game {
+	g := &Game{
+		sessions:   make(map[string]*session),
+		joined:     make(chan bool),
+		batchTurns: 1,
+		deadline:   time.Second,
+		throughput: make(map[string]float64),
+	}
+	if err := g.addWorker(gol.WorkerInfo{Address: startFakeWorker(t), Threads: 1}); err != nil {
+		t.Fatal(err)
+	}
+	return g
+}
+
+// TestSnapshots checks that worlds and alive counts always match the turn they are reported with, and that
+// a paused session stays on the turn it was paused on, while rpcs are made at the same time as turns
+func TestSnapshots(t *testing.T) {
+	g := startGame(t)
+	const turns = 3000
+	p := gol.Params{Turns: turns, ImageWidth: 16, ImageHeight: 4, Session: "snapshots"}
+	var msg string
+	if err := g.Evolve(gol.Args{P: p, World: gol.NewPackedWorld(16, 4)}, &msg); err != nil {
+		t.Fatal(err)
+	}
+
+	var wg sync.WaitGroup
+	wg.Add(3)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 200; i++ {
+			var wc gol.Worldcells
+			if err := g.GetWorld(p.Session, &wc); err != nil {
+				t.Error(err)
+				return
+			}
+			if turnOf(wc.World) != wc.Turn {
+				t.Errorf("world from turn %d reported as turn %d", turnOf(wc.World), wc.Turn)
+			}
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 200; i++ {
+			var tc gol.Turncells
+			g.GetTurncells(p.Session, &tc)
+			if tc.Num_cells != bits.OnesCount(uint(tc.Turn)) {
+				t.Errorf("%d alive cells reported for turn %d", tc.Num_cells, tc.Turn)
+			}
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 20; i++ {
+			var turn int
+			g.Pause(p.Session, &turn)
+			var wc gol.Worldcells
+			g.GetWorld(p.Session, &wc)
+			if wc.Turn != turn {
+				t.Errorf("paused on turn %d but the world is from turn %d", turn, wc.Turn)
+			}
+			g.Resume(p.Session, nil)
+		}
+	}()
+	wg.Wait()
+
+	var wc gol.Worldcells
+	for done := false; !done; g.IsFinished(p.Session, &done) {
+		time.Sleep(time.Millisecond)
+	}
+	g.GetWorld(p.Session, &wc)
+	if wc.Turn != turns || turnOf(wc.World) != turns {
+		t.Errorf("finished on turn %d with a world from turn %d, expected %d", wc.Turn, turnOf(wc.World), turns)
+	}
+}
+
+// TestFlips checks that displays are kept up to date from the cells the nodes say have flipped, without
+// the world being gathered from the nodes
+func TestFlips(t *testing.T) {
+	g := startGame(t)
+	const turns = 3000
+	p := gol.Params{Turns: turns, ImageWidth: 16, ImageHeight: 4, Session: "flips"}
+	var msg string
+	if err := g.Evolve(gol.Args{P: p, World: gol.NewPackedWorld(16, 4)}, &msg); err != nil {
+		t.Fatal(err)
+	}
+	s, _ := g.session(p.Session)
+
+	displayed := gol.Worldcells{World: gol.NewPackedWorld(16, 4), Turn: -1}
+	for displayed.Turn < turns {
+		var flips gol.Flips
+		if err := g.GetFlips(gol.FlipsArgs{Session: p.Session, Since: displayed.Turn}, &flips); err != nil {
+			t.Fatal(err)
+		}
+		if flips.From != displayed.Turn && flips.From != -1 {
+			t.Fatalf("got flips from turn %d for a display showing turn %d", flips.From, displayed.Turn)
+		}
+		if flips.From == -1 {
+			displayed.World = gol.NewPackedWorld(16, 4)
+		}
+		flips.Apply(displayed.World)
+		displayed.Turn = flips.Turn
+		if turnOf(displayed.World) != displayed.Turn {
+			t.Fatalf("display shows the world from turn %d after flipping to turn %d", turnOf(displayed.World), displayed.Turn)
+		}
+		var gathered int
+		s.do(func() {
+			if !s.finished {
+				gathered = s.worldTurn
+			}
+		})
+		if gathered != 0 {
+			t.Fatalf("the world was gathered on turn %d to update the display", gathered)
+		}
+	}
+}
+
+// TestMembership checks that a node that leaves has its strips moved to the other nodes before Leave
+// returns, and that every join and leave reaches the session's controllers with a new epoch
+func TestMembership(t *testing.T) {
+	g := startGame(t)
+	go g.announceMembership()
+	const turns = 2000
+	p := gol.Params{Turns: turns, ImageWidth: 16, ImageHeight: 4, Session: "members"}
+	var msg string
+	if err := g.Evolve(gol.Args{P: p, World: gol.NewPackedWorld(16, 4)}, &msg); err != nil {
+		t.Fatal(err)
+	}
+	s, _ := g.session(p.Session)
+
+	second := startFakeWorker(t)
+	var reply string
+	if err := g.Subscribe(gol.WorkerInfo{Address: second, Threads: 2}, &reply); err != nil {
+		t.Fatal(err)
+	}
+	var membership gol.Membership
+	g.ListWorkers("", &membership)
+	if len(membership.Workers) != 2 || membership.Workers[1].Address != second || membership.Workers[1].State != gol.WorkerActive {
+		t.Fatalf("unexpected workers %+v", membership.Workers)
+	}
+	epoch := membership.Epoch
+
+	// wait for the session to give the new node a strip
+	var distributions int
+	for using := false; !using; {
+		s.do(func() { using, distributions = s.uses(worker{address: second}), s.epoch })
+	}
+	if err := g.Leave(second, nil); err != nil {
+		t.Fatal(err)
+	}
+	s.do(func() {
+		for _, w := range s.active {
+			if w.address == second {
+				t.Error("session still using the node after it left")
+			}
+		}
+	})
+	g.ListWorkers("", &membership)
+	if len(membership.Workers) != 1 || membership.Workers[0].Address == second || membership.Epoch != epoch+1 {
+		t.Errorf("unexpected workers %+v in epoch %d after leaving from epoch %d", membership.Workers, membership.Epoch, epoch)
+	}
+	if err := g.Leave(second, nil); err == nil {
+		t.Error("a node that has already left could leave again")
+	}
+
+	// the session still finishes on the remaining node, and its controllers hear about both changes
+	var states []gol.WorkerState
+	for seq, finished := 0, false; !finished; {
+		var updates []gol.Update
+		g.Updates(gol.UpdatesArgs{Session: p.Session, After: seq, Timeout: 5 * time.Second}, &updates)
+		if len(updates) == 0 {
+			t.Fatal("timed out waiting for the session to finish")
+		}
+		for _, u := range updates {
+			seq = u.Seq
+			finished = u.Kind == gol.Finished
+			if u.Kind == gol.MembershipChanged && u.Member.Address == second {
+				states = append(states, u.Member.State)
+			}
+		}
+	}
+	s.do(func() {
+		if s.epoch != distributions+1 {
+			t.Errorf("the world was distributed %d times for the node leaving, expected once", s.epoch-distributions)
+		}
+	})
+	expected := []gol.WorkerState{gol.WorkerActive, gol.WorkerLeaving, gol.WorkerGone}
+	if fmt.Sprint(states) != fmt.Sprint(expected) {
+		t.Errorf("controllers heard the node go through %v, expected %v", states, expected)
+	}
+	var wc gol.Worldcells
+	g.GetWorld(p.Session, &wc)
+	if wc.Turn != turns || turnOf(wc.World) != turns {
+		t.Errorf("finished on turn %d with the world from turn %d, expected %d", wc.Turn, turnOf(wc.World), turns)
+	}
+}
+
+// slowDropWorker is a fake worker that takes a while to forget a session
+type slowDropWorker struct {
+	fakeWorker
+}
+
+func (f *slowDropWorker) Drop(session string, reply *bool) error {
+	time.Sleep(300 * time.Millisecond)
+	return nil
+}
+
+// TestReplaceSession checks that replacing a finished session doesn't hold up rpcs for every other session
+// while the nodes are told to forget the old one
+func TestReplaceSession(t *testing.T) {
+	g := &Game{
+		sessions:   make(map[string]*session),
+		joined:     make(chan bool),
+		batchTurns: 1,
+		deadline:   time.Second,
+		throughput: make(map[string]float64),
+	}
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := rpc.NewServer()
+	server.RegisterName("Worker", &slowDropWorker{})
+	go server.Accept(listener)
+	if err := g.addWorker(gol.WorkerInfo{Address: listener.Addr().String(), Threads: 1}); err != nil {
+		t.Fatal(err)
+	}
+
+	p := gol.Params{Turns: 1, ImageWidth: 16, ImageHeight: 4, Session: "replaced"}
+	first, err := g.createSession(gol.Args{P: p, World: gol.NewPackedWorld(16, 4)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for first.info().Running {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	replaced := make(chan *session)
+	go func() {
+		s, err := g.createSession(gol.Args{P: p, World: gol.NewPackedWorld(16, 4)})
+		if err != nil {
+			t.Error(err)
+		}
+		replaced <- s
+	}()
+	time.Sleep(50 * time.Millisecond)
+	start := time.Now()
+	var sessions []gol.SessionInfo
+	g.ListSessions("", &sessions)
+	if waited := time.Since(start); waited > 150*time.Millisecond {
+		t.Errorf("listing the sessions took %v while a session was being replaced", waited)
+	}
+	if second := <-replaced; second == first {
+		t.Error("the finished session wasn't replaced")
+	}
+	select {
+	case <-first.done:
+	default:
+		t.Error("the finished session wasn't closed")
+	}
+}
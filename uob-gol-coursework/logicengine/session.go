@@ -0,0 +1,273 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"sync"
+	"time"
+
+	"uk.ac.bris.cs/gameoflife/gol"
+)
+
+// the session used by controllers that don't name one
+const defaultSession = "default"
+
+// the IDs a session can have, they are also used as the names of checkpoint directories
+var sessionID = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+var errClosed = errors.New("session has been closed")
+
+var errUnbounded = errors.New("unbounded worlds can't be split into strips, they only run in the controller's process")
+
+// One simulation hosted by the logic engine. Each session has its own world, params, pause state
+// and turn counter, and the nodes are shared between all of them.
+// Everything apart from the status and updates belongs to the session's start goroutine, rpcs that
+// need to read or change it send a command to the goroutine, which carries it out between turns
+type session struct {
+	g  *Game
+	id string
+
+	commands    chan command
+	done        chan bool       // closed when the start goroutine returns
+	closed      bool            // set to stop the start goroutine when the session is closed
+	finished    bool            // set once every turn has been calculated and the world gathered
+	waiting     bool            // set while there are no nodes to calculate turns on
+	world       gol.PackedWorld // the last world gathered from the nodes
+	worldTurn   int             // the turn that world is from
+	currentTurn int
+	aliveCells  int
+	p           gol.Params
+	rule        gol.Rule
+	paused      bool
+	active      []worker // the nodes currently holding a strip of the world, in order
+	heights     []int    // the number of rows in each active node's strip
+	epoch       int
+	members     int // the membership epoch the world was last distributed in
+	lastBalance time.Time
+
+	lastCheckpointTurn int
+	lastCheckpointTime time.Time
+	checkpointRetry    time.Time // a checkpoint that failed isn't tried again until this time
+
+	frames   gol.Frames         // the last few frames sent to controllers for their displays, the newest is the turn the nodes report flips from
+	recorded gol.RecordedFrames // worlds from the turns being recorded, until the controller collects them
+
+	// a copy of the turn and state made after every turn, which rpcs can read without waiting for the turn to end
+	status     gol.SessionInfo
+	statusLock sync.Mutex
+
+	updates gol.UpdateLog // updates waiting to be collected by the session's controllers
+}
+
+// A request from an rpc that is carried out by the session's start goroutine between turns, so that it
+// always sees the world and the turn counter from the same turn
+type command struct {
+	run  func()
+	done chan bool // closed once the command has been carried out
+}
+
+// sends the function to the start goroutine and waits for it to be run
+func (s *session) do(run func()) error {
+	c := command{run, make(chan bool)}
+	select {
+	case s.commands <- c:
+	case <-s.done:
+		return errClosed
+	}
+	<-c.done
+	return nil
+}
+
+// runs the command and lets the rpc waiting for it carry on
+func (s *session) carryOut(c command) {
+	c.run()
+	s.updateStatus()
+	close(c.done)
+}
+
+// copies the turn and state for the rpcs that don't need to wait for the turn to end
+func (s *session) updateStatus() {
+	s.statusLock.Lock()
+	defer s.statusLock.Unlock()
+	s.status = gol.SessionInfo{
+		ID:         s.id,
+		P:          s.p,
+		Turn:       s.currentTurn,
+		AliveCells: s.aliveCells,
+		Paused:     s.paused,
+		Running:    !s.finished && !s.closed,
+	}
+}
+
+func (s *session) info() gol.SessionInfo {
+	s.statusLock.Lock()
+	defer s.statusLock.Unlock()
+	return s.status
+}
+
+// returns the session with the ID, or the default session if the ID is empty
+func (g *Game) session(id string) (*session, error) {
+	if id == "" {
+		id = defaultSession
+	}
+	g.sessionsLock.Lock()
+	defer g.sessionsLock.Unlock()
+	s, ok := g.sessions[id]
+	if !ok {
+		return nil, fmt.Errorf("no session called %q", id)
+	}
+	return s, nil
+}
+
+// returns a copy of the list of sessions
+func (g *Game) sessionList() []*session {
+	g.sessionsLock.Lock()
+	defer g.sessionsLock.Unlock()
+	list := make([]*session, 0, len(g.sessions))
+	for _, s := range g.sessions {
+		list = append(list, s)
+	}
+	return list
+}
+
+// creates a session that hasn't been added to the logic engine or started yet
+func (g *Game) newSession(id string) *session {
+	return &session{g: g, id: id, commands: make(chan command), done: make(chan bool)}
+}
+
+// adds a session that hasn't been started yet, replacing any finished session with the same ID. The
+// finished session is closed once the sessions lock has been released, as closing it waits on every node
+func (g *Game) addSession(s *session) error {
+	if !sessionID.MatchString(s.id) {
+		return fmt.Errorf("session ID %q should only contain letters, digits, - and _", s.id)
+	}
+	g.sessionsLock.Lock()
+	old, ok := g.sessions[s.id]
+	if ok && old.info().Running {
+		g.sessionsLock.Unlock()
+		return fmt.Errorf("session %q is already running", s.id)
+	}
+	g.sessions[s.id] = s
+	g.sessionsLock.Unlock()
+
+	if ok {
+		// the nodes have to forget their strips of the old session before the new one is started
+		old.close()
+	}
+	return nil
+}
+
+// creates a session from the controller's params and world and starts it
+func (g *Game) createSession(a gol.Args) (*session, error) {
+	if workers, _ := g.activeWorkers(); len(workers) == 0 {
+		return nil, errNoWorkers
+	}
+	rule, err := gol.ParseRule(a.P.Rule)
+	if err != nil {
+		return nil, err
+	}
+	if a.P.Boundary == gol.Unbounded {
+		return nil, errUnbounded
+	}
+	if a.P.Session == "" {
+		a.P.Session = defaultSession
+	}
+	s := g.newSession(a.P.Session)
+	s.p = a.P
+	s.rule = rule
+	s.world = a.World
+	s.aliveCells = a.World.Count()
+	s.lastCheckpointTime = time.Now()
+	if s.p.Record.Wants(0, s.p.Turns) {
+		s.recorded.Add(gol.Worldcells{World: s.world, Turn: 0})
+	}
+	s.updates.Publish(gol.Update{Kind: gol.TurnsCompleted, Turn: 0, AliveCells: s.aliveCells})
+	s.updateStatus()
+	if err := g.addSession(s); err != nil {
+		return nil, err
+	}
+	fmt.Println("Starting session", s.id)
+	go s.start()
+	return s, nil
+}
+
+// tells every node to forget its strip of the session
+func (s *session) dropStrips() {
+	for _, w := range s.g.workerList() {
+		if err := s.g.call(w, "Worker.Drop", s.id, nil); err != nil {
+			fmt.Println("Error dropping session", s.id, "from "+w.address+":", err)
+		}
+	}
+	s.active = nil
+}
+
+// stops the start goroutine and waits for it to return
+func (s *session) close() {
+	s.do(func() {
+		s.closed = true
+		s.updates.Publish(gol.Update{Kind: gol.StateChanged, Turn: s.currentTurn, State: gol.Quitting})
+	})
+	<-s.done
+}
+
+// creates a session and starts it, replying with its ID. If the params don't name the session
+// then the next free ID of the form session-N is used
+func (g *Game) CreateSession(a gol.Args, id *string) (err error) {
+	if a.P.Session == "" {
+		g.sessionsLock.Lock()
+		for n := 1; a.P.Session == ""; n++ {
+			if _, ok := g.sessions[fmt.Sprintf("session-%d", n)]; !ok {
+				a.P.Session = fmt.Sprintf("session-%d", n)
+			}
+		}
+		g.sessionsLock.Unlock()
+	}
+	s, err := g.createSession(a)
+	if err != nil {
+		return
+	}
+	*id = s.id
+	return
+}
+
+// replies with every session hosted by the logic engine, ordered by ID
+func (g *Game) ListSessions(str string, sessions *[]gol.SessionInfo) (err error) {
+	g.sessionsLock.Lock()
+	defer g.sessionsLock.Unlock()
+	list := make([]gol.SessionInfo, 0, len(g.sessions))
+	for _, s := range g.sessions {
+		list = append(list, s.info())
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].ID < list[j].ID })
+	*sessions = list
+	return
+}
+
+// used by a controller to join a session that is already running, replying with its params and turn
+func (g *Game) Attach(id string, info *gol.SessionInfo) (err error) {
+	s, err := g.session(id)
+	if err != nil {
+		return
+	}
+	*info = s.info()
+	return
+}
+
+// stops the session if it is still running and removes it from the logic engine
+func (g *Game) CloseSession(id string, reply *string) (err error) {
+	s, err := g.session(id)
+	if err != nil {
+		return
+	}
+	s.close()
+
+	g.sessionsLock.Lock()
+	if g.sessions[s.id] == s {
+		delete(g.sessions, s.id)
+	}
+	g.sessionsLock.Unlock()
+	fmt.Println("Closed session", s.id)
+	return
+}
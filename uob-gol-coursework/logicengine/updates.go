@@ -0,0 +1,16 @@
+package main
+
+import (
+	"uk.ac.bris.cs/gameoflife/gol"
+)
+
+// called by the controller to wait for something to happen in its session. Replies as soon as there
+// are updates the controller hasn't seen, or with none if the timeout passes first
+func (g *Game) Updates(args gol.UpdatesArgs, updates *[]gol.Update) (err error) {
+	s, err := g.session(args.Session)
+	if err != nil {
+		return
+	}
+	*updates = s.updates.Wait(args.After, args.Timeout)
+	return
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"uk.ac.bris.cs/gameoflife/gol"
+)
+
+// TestUpdates checks that the long-poll replies as soon as there is an update the controller hasn't
+// seen, and with nothing once the timeout passes
+func TestUpdates(t *testing.T) {
+	g := startGame(t)
+	p := gol.Params{Turns: 10, ImageWidth: 16, ImageHeight: 4, Session: "updates"}
+	var msg string
+	if err := g.Evolve(gol.Args{P: p, World: gol.NewPackedWorld(16, 4)}, &msg); err != nil {
+		t.Fatal(err)
+	}
+	// wait for every turn to be done, so only the updates published below can wake the long-poll
+	seq := 0
+	for finished := false; !finished; {
+		var updates []gol.Update
+		if err := g.Updates(gol.UpdatesArgs{Session: p.Session, After: seq, Timeout: 5 * time.Second}, &updates); err != nil {
+			t.Fatal(err)
+		}
+		if len(updates) == 0 {
+			t.Fatal("timed out waiting for the session to finish")
+		}
+		for _, u := range updates {
+			if u.Seq <= seq {
+				t.Errorf("update %d was sent after asking for the ones after %d", u.Seq, seq)
+			}
+			seq = u.Seq
+			finished = u.Kind == gol.Finished
+		}
+	}
+
+	const timeout = 200 * time.Millisecond
+	start := time.Now()
+	var updates []gol.Update
+	if err := g.Updates(gol.UpdatesArgs{Session: p.Session, After: seq, Timeout: timeout}, &updates); err != nil {
+		t.Fatal(err)
+	}
+	if len(updates) != 0 || time.Since(start) < timeout {
+		t.Errorf("got %d updates after %v with nothing happening", len(updates), time.Since(start))
+	}
+
+	s, _ := g.session(p.Session)
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		s.updates.Publish(gol.Update{Kind: gol.StateChanged, Turn: p.Turns, State: gol.Paused})
+	}()
+	start = time.Now()
+	if err := g.Updates(gol.UpdatesArgs{Session: p.Session, After: seq, Timeout: 5 * time.Second}, &updates); err != nil {
+		t.Fatal(err)
+	}
+	if len(updates) != 1 || updates[0].Kind != gol.StateChanged || updates[0].Seq != seq+1 {
+		t.Errorf("got %+v after publishing a state change", updates)
+	}
+	if waited := time.Since(start); waited > time.Second {
+		t.Errorf("the long-poll took %v to reply to an update", waited)
+	}
+
+	if err := g.Updates(gol.UpdatesArgs{Session: "missing"}, &updates); err == nil {
+		t.Error("waiting for updates from a session that doesn't exist didn't fail")
+	}
+}
@@ -0,0 +1,374 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"net/rpc"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"uk.ac.bris.cs/gameoflife/gol"
+)
+
+var errNoWorkers = errors.New("no worker nodes are subscribed to the logic engine")
+var errDeadline = errors.New("node missed its deadline")
+var errLeft = errors.New("node left")
+
+// a node that has subscribed to the logic engine
+type worker struct {
+	address string
+	threads int
+	client  *rpc.Client
+	latency time.Duration // how long the node took to reply to the last ping
+	leaving bool          // set while the node's strips are handed over to the other nodes
+}
+
+func (w worker) status() gol.WorkerStatus {
+	state := gol.WorkerActive
+	if w.leaving {
+		state = gol.WorkerLeaving
+	}
+	return gol.WorkerStatus{Address: w.address, Threads: w.threads, Latency: w.latency, State: state}
+}
+
+// the number of threads the node said it has, which is how much work it is given before it has been timed
+func (w worker) capacity() float64 {
+	if w.threads < 1 {
+		return 1
+	}
+	return float64(w.threads)
+}
+
+// the number of cell-turns per second a node is assumed to manage for each of its threads until it has been timed,
+// which is far slower than any node should be
+const minThroughputPerThread = 1e6
+
+// how many times longer than expected a node can take to calculate its strip, as it may be busy with other sessions
+const workSlack = 4
+
+// the slowest a strip is assumed to be sent over the network, in bytes per second
+const minBandwidth = 1 << 20
+
+// calls the method on the node, giving up if the node hasn't replied within the deadline
+func (g *Game) call(w worker, method string, args interface{}, reply interface{}) error {
+	return g.callWithin(w, method, args, reply, g.deadline)
+}
+
+// calls the method on the node, giving up if the node hasn't replied within the timeout
+func (g *Game) callWithin(w worker, method string, args interface{}, reply interface{}, timeout time.Duration) error {
+	call := w.client.Go(method, args, reply, make(chan *rpc.Call, 1))
+	select {
+	case <-call.Done:
+		return call.Error
+	case <-time.After(timeout):
+		return errDeadline
+	}
+}
+
+// returns how long the node should be given to calculate the number of cell-turns, on top of the deadline
+// every call gets. This is worked out from the node's throughput, or its threads if it hasn't been timed yet
+func (g *Game) computeAllowance(w worker, cellTurns int) time.Duration {
+	throughput, ok := g.measuredThroughput(w.address)
+	if !ok || throughput <= 0 {
+		throughput = minThroughputPerThread * w.capacity()
+	}
+	return time.Duration(float64(cellTurns) / throughput * workSlack * float64(time.Second))
+}
+
+// returns how long sending a strip of the number of cells should be allowed to take, on top of the deadline
+func transferAllowance(cells int) time.Duration {
+	return time.Duration(float64(cells) / 8 / minBandwidth * float64(time.Second))
+}
+
+// returns a copy of the list of subscribed nodes
+func (g *Game) workerList() []worker {
+	g.workersLock.Lock()
+	defer g.workersLock.Unlock()
+	return append([]worker{}, g.workers...)
+}
+
+// returns the nodes that strips can be given to, which are the ones that aren't leaving, along with
+// the membership epoch they are from
+func (g *Game) activeWorkers() ([]worker, int) {
+	g.workersLock.Lock()
+	defer g.workersLock.Unlock()
+	active := make([]worker, 0, len(g.workers))
+	for _, w := range g.workers {
+		if !w.leaving {
+			active = append(active, w)
+		}
+	}
+	return active, g.epoch
+}
+
+// returns the current membership epoch
+func (g *Game) membershipEpoch() int {
+	g.workersLock.Lock()
+	defer g.workersLock.Unlock()
+	return g.epoch
+}
+
+// moves on to the next membership epoch and tells every session about the change to the node.
+// The workers lock must be held
+func (g *Game) membershipChanged(member gol.WorkerStatus) {
+	g.epoch++
+	g.announce(member)
+}
+
+// tells every session about a change to the node that doesn't change which nodes strips can be given to,
+// so it stays in the same membership epoch. The workers lock must be held
+func (g *Game) announce(member gol.WorkerStatus) {
+	active := 0
+	for _, w := range g.workers {
+		if !w.leaving {
+			active++
+		}
+	}
+	g.membership.Publish(gol.Update{Kind: gol.MembershipChanged, Epoch: g.epoch, Member: member, Workers: active})
+}
+
+// passes membership changes on to every session, so that their controllers hear about them. The
+// sessions can't be told directly when the change happens as that could be while the sessions lock is held
+func (g *Game) announceMembership() {
+	seq := 0
+	for {
+		for _, u := range g.membership.Wait(seq, 0) {
+			seq = u.Seq
+			for _, s := range g.sessionList() {
+				u.Turn = s.info().Turn
+				s.updates.Publish(u)
+			}
+		}
+	}
+}
+
+// connects to the node and adds it to the list of subscribed nodes, replacing any old connection to it
+func (g *Game) addWorker(info gol.WorkerInfo) error {
+	client, err := g.security.Dial(info.Address)
+	if err != nil {
+		return err
+	}
+	g.workersLock.Lock()
+	// a node that was dropped and has recovered replaces its old connection
+	for i, w := range g.workers {
+		if w.address == info.Address {
+			w.client.Close()
+			g.workers = append(g.workers[:i], g.workers[i+1:]...)
+			break
+		}
+	}
+	w := worker{address: info.Address, threads: info.Threads, client: client}
+	g.workers = append(g.workers, w)
+	g.membershipChanged(w.status())
+	g.workersLock.Unlock()
+	g.workerJoined()
+	return nil
+}
+
+// removes the node from the list of subscribed nodes and closes the connection to it, which
+// also stops any calls to it that are still waiting
+func (g *Game) removeWorker(dropped worker, reason error) {
+	g.workersLock.Lock()
+	defer g.workersLock.Unlock()
+	newWorkers := make([]worker, 0)
+	found := false
+	for _, w := range g.workers {
+		if w.client != dropped.client {
+			newWorkers = append(newWorkers, w)
+		} else {
+			dropped = w
+			found = true
+		}
+	}
+	if !found {
+		return
+	}
+	fmt.Println("Dropping worker", dropped.address, reason)
+	dropped.client.Close()
+	g.workers = newWorkers
+	status := dropped.status()
+	status.State = gol.WorkerGone
+	if dropped.leaving {
+		// the epoch moved on when the node started leaving, and no strips have been given to it since
+		g.announce(status)
+	} else {
+		g.membershipChanged(status)
+	}
+	if len(g.workers) == 0 {
+		fmt.Println("Error:", errNoWorkers)
+	}
+}
+
+// pings every subscribed node each interval, removing any that don't reply in time, and tries to connect
+// to any peers from the peer file that aren't subscribed
+func (g *Game) heartbeat(interval time.Duration) {
+	for range time.Tick(interval) {
+		for _, w := range g.workerList() {
+			go func(w worker) {
+				var ok bool
+				started := time.Now()
+				if err := g.call(w, "Worker.Ping", "", &ok); err != nil {
+					g.removeWorker(w, err)
+				} else {
+					g.setLatency(w, time.Since(started))
+				}
+			}(w)
+		}
+		g.connectPeers()
+	}
+}
+
+func (g *Game) setLatency(pinged worker, latency time.Duration) {
+	g.workersLock.Lock()
+	defer g.workersLock.Unlock()
+	for i, w := range g.workers {
+		if w.client == pinged.client {
+			g.workers[i].latency = latency
+		}
+	}
+}
+
+// returns the peers from the peer file that aren't subscribed
+func (g *Game) missingPeers() []gol.WorkerInfo {
+	g.workersLock.Lock()
+	defer g.workersLock.Unlock()
+	var missing []gol.WorkerInfo
+	for _, peer := range g.peers {
+		found := false
+		for _, w := range g.workers {
+			found = found || w.address == peer.Address
+		}
+		if !found {
+			missing = append(missing, peer)
+		}
+	}
+	return missing
+}
+
+// connects to the peers from the peer file that aren't subscribed, so that nodes can be added without
+// having to subscribe themselves
+func (g *Game) connectPeers() {
+	for _, peer := range g.missingPeers() {
+		if err := g.addWorker(peer); err != nil {
+			fmt.Println("Error connecting to peer", peer.Address, err)
+		} else {
+			fmt.Println("Connected to peer", peer.Address)
+		}
+	}
+}
+
+// reads a peer file, which has the address of a node on each line, optionally followed by its number
+// of threads. Blank lines and lines starting with # are ignored
+func readPeers(file string) ([]gol.WorkerInfo, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var peers []gol.WorkerInfo
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		peer := gol.WorkerInfo{Address: fields[0], Threads: 1}
+		if len(fields) > 1 {
+			if peer.Threads, err = strconv.Atoi(fields[1]); err != nil || len(fields) > 2 {
+				return nil, fmt.Errorf("%s:%d: expected an address and a number of threads", file, line)
+			}
+		}
+		peers = append(peers, peer)
+	}
+	return peers, scanner.Err()
+}
+
+// replies with every node the logic engine knows about, including peers from the peer file it can't connect to
+func (g *Game) ListWorkers(str string, membership *gol.Membership) (err error) {
+	missing := g.missingPeers()
+	g.workersLock.Lock()
+	defer g.workersLock.Unlock()
+	membership.Epoch = g.epoch
+	membership.Workers = make([]gol.WorkerStatus, 0, len(g.workers)+len(missing))
+	for _, w := range g.workers {
+		membership.Workers = append(membership.Workers, w.status())
+	}
+	for _, peer := range missing {
+		membership.Workers = append(membership.Workers, gol.WorkerStatus{Address: peer.Address, Threads: peer.Threads, State: gol.WorkerUnreachable})
+	}
+	return
+}
+
+// called by a node that wants to leave. Every session using the node hands its strips over to the other
+// nodes before the reply is sent, so the node can shut down as soon as it gets the reply
+func (g *Game) Leave(address string, reply *bool) (err error) {
+	g.workersLock.Lock()
+	var w worker
+	found := false
+	for i := range g.workers {
+		if g.workers[i].address == address {
+			g.workers[i].leaving = true
+			w = g.workers[i]
+			found = true
+		}
+	}
+	if !found {
+		g.workersLock.Unlock()
+		return fmt.Errorf("no node at %s", address)
+	}
+	// this is the only epoch change for the node leaving, every session moves its strips off the node in it
+	g.membershipChanged(w.status())
+	g.workersLock.Unlock()
+	fmt.Println("Worker", address, "leaving")
+
+	for _, s := range g.sessionList() {
+		s.do(func() {
+			if !s.uses(w) {
+				return
+			}
+			err := s.gather()
+			if err == nil {
+				err = s.distribute()
+			}
+			if err != nil {
+				fmt.Println("Error moving strips of session", s.id, "off leaving worker:", err)
+			}
+		})
+	}
+	g.removeWorker(w, errLeft)
+	return
+}
+
+// used by the nodes to check that the logic engine still knows about them, a node that
+// gets false back subscribes again
+func (g *Game) IsSubscribed(address string, subscribed *bool) (err error) {
+	for _, w := range g.workerList() {
+		if w.address == address {
+			*subscribed = true
+		}
+	}
+	return
+}
+
+// wakes every session that is waiting for a node to subscribe
+func (g *Game) workerJoined() {
+	g.workersLock.Lock()
+	defer g.workersLock.Unlock()
+	close(g.joined)
+	g.joined = make(chan bool)
+}
+
+// returns a channel that is closed when the next node subscribes, and whether any nodes are subscribed now
+func (g *Game) joinedSignal() (chan bool, bool) {
+	g.workersLock.Lock()
+	defer g.workersLock.Unlock()
+	for _, w := range g.workers {
+		if !w.leaving {
+			return g.joined, true
+		}
+	}
+	return g.joined, false
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"net"
+	"net/rpc"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"uk.ac.bris.cs/gameoflife/gol"
+)
+
+// sleepyWorker is a fake worker that stops replying to pings while it is asleep
+type sleepyWorker struct {
+	fakeWorker
+	asleep int32
+}
+
+func (f *sleepyWorker) Ping(msg string, reply *bool) error {
+	for atomic.LoadInt32(&f.asleep) != 0 {
+		time.Sleep(10 * time.Millisecond)
+	}
+	*reply = true
+	return nil
+}
+
+// TestDeadlines checks that nodes are given longer to reply to calls with more work in them, and that a
+// node that has been timed is given time in line with how fast it was
+func TestDeadlines(t *testing.T) {
+	g := &Game{throughput: make(map[string]float64)}
+	unmeasured := worker{address: "unmeasured", threads: 4}
+	if small, large := g.computeAllowance(unmeasured, 1e6), g.computeAllowance(unmeasured, 1e9); large <= small || large < time.Second {
+		t.Errorf("an unmeasured node is given %v for a thousand times the work it gets %v for", large, small)
+	}
+	if fewer := g.computeAllowance(worker{address: "slow", threads: 1}, 1e9); fewer <= g.computeAllowance(unmeasured, 1e9) {
+		t.Error("a node with fewer threads isn't given longer for the same work")
+	}
+
+	measured := worker{address: "measured", threads: 4}
+	g.throughput[measured.address] = 1e8
+	allowance := g.computeAllowance(measured, 1e9)
+	if expected := 10 * workSlack * time.Second; allowance != expected {
+		t.Errorf("a node that calculates 1e8 cells a second is given %v for 1e9 cells, expected %v", allowance, expected)
+	}
+	g.throughput[measured.address] = 1e7
+	if slower := g.computeAllowance(measured, 1e9); slower <= allowance {
+		t.Error("a node measured as slower isn't given longer")
+	}
+
+	if small, large := transferAllowance(1<<10), transferAllowance(1<<30); large <= small || large < time.Second {
+		t.Errorf("a strip a million times bigger is given %v to send, compared to %v", large, small)
+	}
+}
+
+// TestHeartbeat checks that a node that stops answering pings is dropped with a new membership epoch, and
+// that it is back in the next epoch once it subscribes again
+func TestHeartbeat(t *testing.T) {
+	g := &Game{
+		sessions:   make(map[string]*session),
+		joined:     make(chan bool),
+		deadline:   100 * time.Millisecond,
+		throughput: make(map[string]float64),
+	}
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	node := &sleepyWorker{}
+	server := rpc.NewServer()
+	server.RegisterName("Worker", node)
+	go server.Accept(listener)
+	info := gol.WorkerInfo{Address: listener.Addr().String(), Threads: 1}
+	var reply string
+	if err := g.Subscribe(info, &reply); err != nil {
+		t.Fatal(err)
+	}
+	go g.heartbeat(20 * time.Millisecond)
+
+	subscribed := func() bool {
+		var subscribed bool
+		g.IsSubscribed(info.Address, &subscribed)
+		return subscribed
+	}
+	// a node that answers its pings stays subscribed
+	time.Sleep(5 * g.deadline)
+	if !subscribed() {
+		t.Fatal("a node answering its pings was dropped")
+	}
+	epoch := g.membershipEpoch()
+
+	atomic.StoreInt32(&node.asleep, 1)
+	for start := time.Now(); subscribed(); time.Sleep(10 * time.Millisecond) {
+		if time.Since(start) > 5*time.Second {
+			t.Fatal("a node that stopped answering pings wasn't dropped")
+		}
+	}
+	if dropped := g.membershipEpoch(); dropped != epoch+1 {
+		t.Errorf("membership epoch is %d after dropping a node in epoch %d", dropped, epoch)
+	}
+
+	// the node sees that it isn't subscribed and subscribes again, as the node's stayConnected does
+	atomic.StoreInt32(&node.asleep, 0)
+	if err := g.Subscribe(info, &reply); err != nil {
+		t.Fatal(err)
+	}
+	if !subscribed() {
+		t.Fatal("the node isn't subscribed after subscribing again")
+	}
+	if rejoined := g.membershipEpoch(); rejoined != epoch+2 {
+		t.Errorf("membership epoch is %d after the node rejoined, expected %d", rejoined, epoch+2)
+	}
+	time.Sleep(5 * g.deadline)
+	if !subscribed() {
+		t.Error("the node was dropped again after subscribing again")
+	}
+}
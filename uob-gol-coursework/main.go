@@ -3,9 +3,12 @@ package main
 import (
 	"flag"
 	"fmt"
+	"os"
 	"runtime"
 
 	"uk.ac.bris.cs/gameoflife/gol"
+	"uk.ac.bris.cs/gameoflife/netpbm"
+	"uk.ac.bris.cs/gameoflife/pattern"
 )
 
 // main is the function called when starting Game of Life with 'go run .'
@@ -22,14 +25,14 @@ func main() {
 	flag.IntVar(
 		&params.ImageWidth,
 		"w",
-		512,
-		"Specify the width of the image. Defaults to 512.")
+		0,
+		"Specify the width of the image. Defaults to the width in the header of the input image or pattern, or 512.")
 
 	flag.IntVar(
 		&params.ImageHeight,
 		"h",
-		512,
-		"Specify the height of the image. Defaults to 512.")
+		0,
+		"Specify the height of the image. Defaults to the height in the header of the input image or pattern, or 512.")
 
 	flag.IntVar(
 		&params.Turns,
@@ -37,11 +40,200 @@ func main() {
 		10000000000,
 		"Specify the number of turns to process. Defaults to 10000000000.")
 
+	flag.IntVar(
+		&params.BatchTurns,
+		"batch",
+		0,
+		"Specify the number of turns the nodes calculate between halo exchanges. Defaults to the logic engine's setting.")
+
+	flag.StringVar(
+		&params.Rule,
+		"rule",
+		gol.Conway,
+		"Specify the rule in B/S notation, e.g. B36/S23 for HighLife. Defaults to B3/S23.")
+
+	flag.Var(
+		&params.Boundary,
+		"boundary",
+		"Specify what happens at the edges of the world: torus, dead, reflect, klein or unbounded. Defaults to torus.")
+
+	flag.Var(
+		&params.Backend,
+		"backend",
+		"Specify how turns are calculated: strips, or hashlife for very long runs of worlds with power of two sizes. Defaults to strips.")
+
+	flag.IntVar(
+		&params.Jump,
+		"jump",
+		0,
+		"Specify the log2 of the turns hashlife calculates at once. Defaults to growing the jump for as long as jumps are quick.")
+
+	flag.StringVar(
+		&params.Input,
+		"input",
+		"",
+		"Specify the pbm or pgm image to start from. Defaults to images/<width>x<height>.pgm.")
+
+	flag.StringVar(
+		&params.OutputDir,
+		"out",
+		"out",
+		"Specify the directory worlds and recordings are saved in. Defaults to out.")
+
+	flag.StringVar(
+		&params.OutputName,
+		"name",
+		"{width}x{height}x{turn}",
+		"Specify the names of saved files, where {turn}, {width}, {height} and {rule} are filled in. Defaults to {width}x{height}x{turn}.")
+
+	flag.IntVar(
+		&params.Threshold,
+		"threshold",
+		netpbm.DefaultThreshold,
+		"Specify the grey level out of 255 from which a pixel of the input image is an alive cell.")
+
+	flag.StringVar(
+		&params.Pattern,
+		"pattern",
+		"",
+		"Specify an RLE, Life 1.05/1.06 or plaintext (.cells) pattern file to start from instead of the image.")
+
+	flag.IntVar(
+		&params.Placement.Offset.X,
+		"pattern-x",
+		0,
+		"Specify the column the left of the pattern goes in, or how far right of the centre it goes with -centre.")
+
+	flag.IntVar(
+		&params.Placement.Offset.Y,
+		"pattern-y",
+		0,
+		"Specify the row the top of the pattern goes in, or how far below the centre it goes with -centre.")
+
+	flag.BoolVar(
+		&params.Placement.Centre,
+		"centre",
+		false,
+		"Put the pattern in the middle of the world.")
+
+	flag.StringVar(
+		&params.SaveFormat,
+		"save-format",
+		"pgm",
+		"Specify the format worlds are saved in: pgm, png, rle, life105, life106 or cells. Defaults to pgm.")
+
+	flag.IntVar(
+		&params.Record.Stride,
+		"record",
+		0,
+		"Specify the turns between frames of an animated GIF of the run, saved in out. Defaults to 0, which records nothing.")
+
+	flag.IntVar(
+		&params.Record.From,
+		"record-from",
+		0,
+		"Specify the first turn recorded. Defaults to 0.")
+
+	flag.IntVar(
+		&params.Record.To,
+		"record-to",
+		0,
+		"Specify the last turn recorded. Defaults to 0, which records until the last turn.")
+
+	flag.IntVar(
+		&params.Record.Scale,
+		"record-scale",
+		0,
+		"Specify the cells along each side of a pixel of the recording. Defaults to 0, which keeps the recording at most 512 pixels across.")
+
+	flag.IntVar(
+		&params.Record.Delay,
+		"record-delay",
+		10,
+		"Specify the hundredths of a second each frame of the recording is shown for. Defaults to 10.")
+
+	flag.StringVar(
+		&params.Session,
+		"session",
+		"",
+		"Specify the logic engine session to start, or to attach to if it is already running. Defaults to the default session.")
+
+	flag.StringVar(
+		&params.Server,
+		"server",
+		"",
+		"Specify the address of the logic engine. Defaults to SERVER from the environment, or running locally if that isn't set.")
+
+	params.Security.Flags(flag.CommandLine)
+
+	listSessions := flag.Bool(
+		"sessions",
+		false,
+		"List the sessions running on the logic engine and exit.")
+
+	listWorkers := flag.Bool(
+		"workers",
+		false,
+		"List the nodes of the logic engine and exit.")
+
 	flag.Parse()
 
+	if params.SaveFormat != "pgm" && params.SaveFormat != "png" {
+		if _, err := pattern.ParseFormat(params.SaveFormat); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+	}
+
+	if *listSessions {
+		sessions, err := gol.ListSessions(params)
+		if err != nil {
+			fmt.Println("Error listing sessions:", err)
+			os.Exit(1)
+		}
+		for _, s := range sessions {
+			fmt.Printf("%s\t%dx%d\tturn %d/%d\trunning: %v\tpaused: %v\n",
+				s.ID, s.P.ImageWidth, s.P.ImageHeight, s.Turn, s.P.Turns, s.Running, s.Paused)
+		}
+		return
+	}
+
+	if *listWorkers {
+		membership, err := gol.ListWorkers(params)
+		if err != nil {
+			fmt.Println("Error listing nodes:", err)
+			os.Exit(1)
+		}
+		fmt.Println("Epoch:", membership.Epoch)
+		for _, w := range membership.Workers {
+			fmt.Printf("%s\t%d threads\t%v\t%v\n", w.Address, w.Threads, w.Latency, w.State)
+		}
+		return
+	}
+
+	// without a file to read the size from, the default image is used
+	if params.Input == "" && params.Pattern == "" {
+		if params.ImageWidth == 0 {
+			params.ImageWidth = 512
+		}
+		if params.ImageHeight == 0 {
+			params.ImageHeight = 512
+		}
+	}
+	params, err := gol.FillSize(params)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+
 	fmt.Println("Threads:", params.Threads)
 	fmt.Println("Width:", params.ImageWidth)
 	fmt.Println("Height:", params.ImageHeight)
+	fmt.Println("Rule:", params.Rule)
+	fmt.Println("Boundary:", params.Boundary)
+	fmt.Println("Backend:", params.Backend)
+	fmt.Println("Session:", params.Session)
+	fmt.Println("Server:", gol.ServerAddress(params))
 
 	keyPresses := make(chan rune, 10)
 	events := make(chan gol.Event, 1000)
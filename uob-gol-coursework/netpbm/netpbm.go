@@ -0,0 +1,341 @@
+// Package netpbm reads and writes the Netpbm formats worlds are stored in: PBM (P1 and P4) and PGM (P2 and P5),
+// in both their plain (text) and raw (binary) forms, with comments anywhere they are allowed in the header
+package netpbm
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// Format is the magic number an image starts with
+type Format string
+
+const (
+	PlainPBM Format = "P1" // one 0 or 1 per pixel as text, 1 is black
+	PlainPGM Format = "P2" // one decimal grey level per pixel as text
+	PBM      Format = "P4" // eight pixels to a byte, each row padded to a whole byte
+	PGM      Format = "P5" // a byte per pixel, or two bytes with the most significant first if the maxval is over 255
+)
+
+// DefaultThreshold is the grey level out of 255 from which a pixel is an alive cell
+const DefaultThreshold = 128
+
+// the largest maxval a PGM can have
+const maxMaxVal = 65535
+
+// MaxPixels is the most pixels an image can have, so a forged header can't make Decode run out of memory
+const MaxPixels = 1 << 26
+
+// Image is a PBM or PGM image
+type Image struct {
+	Format   Format
+	Width    int
+	Height   int
+	MaxVal   int      // the grey level of white, always 1 for PBM
+	Pix      []uint16 // the pixels row by row. For PBM 1 is black, for PGM 0 is black and MaxVal is white
+	Comments []string // the comments in the header, without the #
+}
+
+// PBM returns whether the image is a bitmap rather than a greymap
+func (f Format) PBM() bool {
+	return f == PlainPBM || f == PBM
+}
+
+// Decode reads an image in any of the formats
+func Decode(r io.Reader) (*Image, error) {
+	in := bufio.NewReader(r)
+	img, err := decodeHeader(in)
+	if err != nil {
+		return nil, err
+	}
+
+	// the pixels are added as they are read, so a file that is shorter than its header says fails before
+	// all of them are allocated
+	switch img.Format {
+	case PlainPBM, PlainPGM:
+		err = img.readPlain(in)
+	case PBM:
+		err = img.readPBM(in)
+	case PGM:
+		err = img.readPGM(in)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("reading the pixels: %v", err)
+	}
+	return img, nil
+}
+
+// DecodeConfig reads just the header of an image, returning it without any pixels
+func DecodeConfig(r io.Reader) (*Image, error) {
+	return decodeHeader(bufio.NewReader(r))
+}
+
+// reads the magic number, size and maxval, leaving the reader at the first pixel
+func decodeHeader(in *bufio.Reader) (*Image, error) {
+	magic := make([]byte, 2)
+	if _, err := io.ReadFull(in, magic); err != nil {
+		return nil, fmt.Errorf("reading the magic number: %v", err)
+	}
+	img := &Image{Format: Format(magic), MaxVal: 1}
+	switch img.Format {
+	case PlainPBM, PlainPGM, PBM, PGM:
+	default:
+		return nil, fmt.Errorf("%q isn't a PBM or PGM magic number", magic)
+	}
+
+	header := []*int{&img.Width, &img.Height}
+	if !img.Format.PBM() {
+		header = append(header, &img.MaxVal)
+	}
+	for _, field := range header {
+		value, err := readNumber(in, &img.Comments)
+		if err != nil {
+			return nil, fmt.Errorf("reading the header: %v", err)
+		}
+		*field = value
+	}
+	if img.Width <= 0 || img.Height <= 0 {
+		return nil, fmt.Errorf("image is %dx%d", img.Width, img.Height)
+	}
+	if img.Width > MaxPixels/img.Height {
+		return nil, fmt.Errorf("%dx%d image has more than %d pixels", img.Width, img.Height, MaxPixels)
+	}
+	if img.MaxVal <= 0 || img.MaxVal > maxMaxVal {
+		return nil, fmt.Errorf("maxval %d is outside 1 to %d", img.MaxVal, maxMaxVal)
+	}
+	return img, nil
+}
+
+// reads a decimal number, skipping the whitespace and comments before it and using up the one whitespace
+// character after it, which is all there is between the header and the pixels of a raw image
+func readNumber(in *bufio.Reader, comments *[]string) (int, error) {
+	if err := skipSpace(in, comments); err != nil {
+		return 0, err
+	}
+	var digits []byte
+	for {
+		b, err := in.ReadByte()
+		if err == io.EOF && len(digits) > 0 {
+			break
+		} else if err != nil {
+			return 0, err
+		}
+		if b == '#' {
+			in.UnreadByte()
+			break
+		}
+		if isSpace(b) {
+			break
+		}
+		if b < '0' || b > '9' {
+			return 0, fmt.Errorf("unexpected %q in a number", b)
+		}
+		digits = append(digits, b)
+		if len(digits) > 9 {
+			return 0, errors.New("number is too big")
+		}
+	}
+	return strconv.Atoi(string(digits))
+}
+
+// skips whitespace and comments, adding the comments to the list
+func skipSpace(in *bufio.Reader, comments *[]string) error {
+	for {
+		b, err := in.ReadByte()
+		if err != nil {
+			return err
+		}
+		switch {
+		case b == '#':
+			comment, err := in.ReadString('\n')
+			if err != nil && err != io.EOF {
+				return err
+			}
+			if comments != nil {
+				*comments = append(*comments, strings.TrimSpace(comment))
+			}
+		case !isSpace(b):
+			return in.UnreadByte()
+		}
+	}
+}
+
+func isSpace(b byte) bool {
+	return b == ' ' || b == '\t' || b == '\n' || b == '\r' || b == '\v' || b == '\f'
+}
+
+func (img *Image) readPlain(in *bufio.Reader) error {
+	for i := 0; i < img.Width*img.Height; i++ {
+		if img.Format == PlainPGM {
+			value, err := readNumber(in, nil)
+			if err != nil {
+				return err
+			}
+			if value > img.MaxVal {
+				return fmt.Errorf("pixel %d is over the maxval %d", value, img.MaxVal)
+			}
+			img.Pix = append(img.Pix, uint16(value))
+			continue
+		}
+		// PBM pixels don't need any space between them
+		if err := skipSpace(in, nil); err != nil {
+			return err
+		}
+		b, _ := in.ReadByte()
+		if b != '0' && b != '1' {
+			return fmt.Errorf("unexpected %q in a bitmap", b)
+		}
+		img.Pix = append(img.Pix, uint16(b-'0'))
+	}
+	return nil
+}
+
+func (img *Image) readPBM(in *bufio.Reader) error {
+	row := make([]byte, (img.Width+7)/8)
+	for y := 0; y < img.Height; y++ {
+		if _, err := io.ReadFull(in, row); err != nil {
+			return err
+		}
+		for x := 0; x < img.Width; x++ {
+			img.Pix = append(img.Pix, uint16(row[x/8]>>uint(7-x%8))&1)
+		}
+	}
+	return nil
+}
+
+func (img *Image) readPGM(in *bufio.Reader) error {
+	size := 1
+	if img.MaxVal > 255 {
+		size = 2
+	}
+	row := make([]byte, img.Width*size)
+	for y := 0; y < img.Height; y++ {
+		if _, err := io.ReadFull(in, row); err != nil {
+			return err
+		}
+		for x := 0; x < img.Width; x++ {
+			value := uint16(row[x*size])
+			if size == 2 {
+				value = value<<8 | uint16(row[x*size+1])
+			}
+			if int(value) > img.MaxVal {
+				return fmt.Errorf("pixel %d is over the maxval %d", value, img.MaxVal)
+			}
+			img.Pix = append(img.Pix, value)
+		}
+	}
+	return nil
+}
+
+// Encode writes the image in its format, with its comments after the magic number
+func Encode(w io.Writer, img *Image) error {
+	if len(img.Pix) != img.Width*img.Height {
+		return fmt.Errorf("%dx%d image has %d pixels", img.Width, img.Height, len(img.Pix))
+	}
+	out := bufio.NewWriter(w)
+	fmt.Fprintf(out, "%s\n", img.Format)
+	for _, comment := range img.Comments {
+		fmt.Fprintf(out, "# %s\n", strings.Replace(comment, "\n", " ", -1))
+	}
+	fmt.Fprintf(out, "%d %d\n", img.Width, img.Height)
+	if !img.Format.PBM() {
+		fmt.Fprintf(out, "%d\n", img.MaxVal)
+	}
+
+	switch img.Format {
+	case PlainPBM, PlainPGM:
+		img.writePlain(out)
+	case PBM:
+		row := make([]byte, (img.Width+7)/8)
+		for y := 0; y < img.Height; y++ {
+			for i := range row {
+				row[i] = 0
+			}
+			for x, value := range img.Pix[y*img.Width : (y+1)*img.Width] {
+				if value != 0 {
+					row[x/8] |= 0x80 >> uint(x%8)
+				}
+			}
+			out.Write(row)
+		}
+	case PGM:
+		for _, value := range img.Pix {
+			if img.MaxVal > 255 {
+				out.WriteByte(byte(value >> 8))
+			}
+			out.WriteByte(byte(value))
+		}
+	default:
+		return fmt.Errorf("can't write %q images", img.Format)
+	}
+	return out.Flush()
+}
+
+// writes one row of pixels per line, keeping lines to at most 70 characters
+func (img *Image) writePlain(out *bufio.Writer) {
+	for y := 0; y < img.Height; y++ {
+		length := 0
+		for _, value := range img.Pix[y*img.Width : (y+1)*img.Width] {
+			text := strconv.Itoa(int(value))
+			if img.Format == PlainPGM && length > 0 {
+				text = " " + text
+			}
+			if length+len(text) > 70 {
+				out.WriteByte('\n')
+				text = strings.TrimPrefix(text, " ")
+				length = 0
+			}
+			out.WriteString(text)
+			length += len(text)
+		}
+		out.WriteByte('\n')
+	}
+}
+
+// Cells turns the image into a world, with 255 for alive cells and 0 for dead ones. In a PBM black pixels
+// are alive, in a PGM pixels are alive if their grey level, scaled to be out of 255, is at least the threshold
+func (img *Image) Cells(threshold int) [][]byte {
+	world := make([][]byte, img.Height)
+	for y := range world {
+		world[y] = make([]byte, img.Width)
+		for x, value := range img.Pix[y*img.Width : (y+1)*img.Width] {
+			alive := value != 0
+			if !img.Format.PBM() {
+				alive = int(value)*255 >= threshold*img.MaxVal
+			}
+			if alive {
+				world[y][x] = 255
+			}
+		}
+	}
+	return world
+}
+
+// FromCells turns a world, where any cell that isn't 0 is alive, into an image of the format. Alive cells
+// are black in a PBM and white in a PGM, which has a maxval of 255
+func FromCells(format Format, world [][]byte) *Image {
+	img := &Image{Format: format, Height: len(world), MaxVal: 1}
+	if len(world) > 0 {
+		img.Width = len(world[0])
+	}
+	alive := uint16(1)
+	if !format.PBM() {
+		img.MaxVal = 255
+		alive = 255
+	}
+	img.Pix = make([]uint16, 0, img.Width*img.Height)
+	for _, row := range world {
+		for _, cell := range row {
+			if cell != 0 {
+				img.Pix = append(img.Pix, alive)
+			} else {
+				img.Pix = append(img.Pix, 0)
+			}
+		}
+	}
+	return img
+}
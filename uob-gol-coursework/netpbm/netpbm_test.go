@@ -0,0 +1,113 @@
+package netpbm
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// TestRoundTrip writes an image in every format and reads it back
+func TestRoundTrip(t *testing.T) {
+	// 75 pixels wide so the plain formats have to split rows, and the bitmap rows don't fill whole bytes
+	const width, height = 75, 3
+	for _, format := range []Format{PlainPBM, PlainPGM, PBM, PGM} {
+		for _, maxVal := range []int{1, 255, 1000} {
+			if format.PBM() != (maxVal == 1) {
+				continue
+			}
+			img := &Image{Format: format, Width: width, Height: height, MaxVal: maxVal, Comments: []string{"origin -3 7"}}
+			for i := 0; i < width*height; i++ {
+				img.Pix = append(img.Pix, uint16(i*7%(maxVal+1)))
+			}
+			var out bytes.Buffer
+			if err := Encode(&out, img); err != nil {
+				t.Fatal(err)
+			}
+			for _, line := range strings.Split(out.String(), "\n") {
+				if !format.PBM() && format != PGM && len(line) > 70 {
+					t.Errorf("%s has a line longer than 70 characters", format)
+				}
+			}
+			read, err := Decode(&out)
+			if err != nil {
+				t.Fatalf("reading %s with maxval %d: %v", format, maxVal, err)
+			}
+			if !reflect.DeepEqual(read, img) {
+				t.Errorf("%s with maxval %d changed after writing and reading it", format, maxVal)
+			}
+		}
+	}
+}
+
+// TestHeader checks comments in the header, and pixels that are the same as whitespace or #
+func TestHeader(t *testing.T) {
+	data := "P5 # a comment after the magic number\n# and one on its own line\n4#width\n1\n255\n" + "\n #\t"
+	img, err := Decode(strings.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if img.Width != 4 || img.Height != 1 || img.MaxVal != 255 {
+		t.Errorf("read a %dx%d image with maxval %d, expected 4x1 with maxval 255", img.Width, img.Height, img.MaxVal)
+	}
+	if expected := []uint16{'\n', ' ', '#', '\t'}; !reflect.DeepEqual(img.Pix, expected) {
+		t.Errorf("read pixels %v, expected %v", img.Pix, expected)
+	}
+	if expected := []string{"a comment after the magic number", "and one on its own line", "width"}; !reflect.DeepEqual(img.Comments, expected) {
+		t.Errorf("read comments %q, expected %q", img.Comments, expected)
+	}
+
+	// the header can be read without the pixels being there
+	img, err = DecodeConfig(strings.NewReader("P5\n# size\n640 480\n255\n"))
+	if err != nil || img.Width != 640 || img.Height != 480 || img.Pix != nil {
+		t.Errorf("read the header as %+v, %v", img, err)
+	}
+
+	// plain bitmaps don't need spaces between pixels
+	img, err = Decode(strings.NewReader("P1\n3 2\n010\n1 1\n# comment\n1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := []uint16{0, 1, 0, 1, 1, 1}; !reflect.DeepEqual(img.Pix, expected) {
+		t.Errorf("read pixels %v, expected %v", img.Pix, expected)
+	}
+
+	for _, bad := range []string{"P6\n1 1\n255\n\x00", "P5\n2 2\n255\n\x00", "P2\n1 1\n10\n11", "P5\n0 1\n255\n", "P4\n-1 1\n"} {
+		if _, err := Decode(strings.NewReader(bad)); err == nil {
+			t.Errorf("read %q without an error", bad)
+		}
+	}
+}
+
+// TestHugeHeader checks that a header claiming more pixels than an image can have is rejected before any
+// pixels are allocated, as is a file much shorter than its header says
+func TestHugeHeader(t *testing.T) {
+	huge := "P5 99999999 99999999 255\n"
+	if _, err := DecodeConfig(strings.NewReader(huge)); err == nil {
+		t.Error("read the header of a 99999999x99999999 image without an error")
+	}
+	if _, err := Decode(strings.NewReader(huge)); err == nil {
+		t.Error("read a 99999999x99999999 image without an error")
+	}
+	if _, err := Decode(strings.NewReader("P5 8192 8192 255\n\x00\x00")); err == nil {
+		t.Error("read an 8192x8192 image from two pixels without an error")
+	}
+}
+
+// TestCells checks which pixels become alive cells
+func TestCells(t *testing.T) {
+	grey := &Image{Format: PlainPGM, Width: 4, Height: 1, MaxVal: 1000, Pix: []uint16{0, 400, 600, 1000}}
+	if cells, expected := grey.Cells(DefaultThreshold), [][]byte{{0, 0, 255, 255}}; !reflect.DeepEqual(cells, expected) {
+		t.Errorf("got cells %v, expected %v", cells, expected)
+	}
+	if cells, expected := grey.Cells(1), [][]byte{{0, 255, 255, 255}}; !reflect.DeepEqual(cells, expected) {
+		t.Errorf("got cells %v with a threshold of 1, expected %v", cells, expected)
+	}
+
+	world := [][]byte{{255, 0}, {0, 255}}
+	for _, format := range []Format{PlainPBM, PBM, PlainPGM, PGM} {
+		if cells := FromCells(format, world).Cells(DefaultThreshold); !reflect.DeepEqual(cells, world) {
+			t.Errorf("%s gave back cells %v, expected %v", format, cells, world)
+		}
+	}
+}
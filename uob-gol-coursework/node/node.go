@@ -1,22 +1,90 @@
 package main
 
 import (
+	"errors"
 	"flag"
 	"fmt"
-	"net"
 	"net/rpc"
+	"os"
+	"os/signal"
 	"sync"
+	"syscall"
+	"time"
 
 	"uk.ac.bris.cs/gameoflife/gol"
 )
 
 const alive = 255
-const dead = 0
+
+// how long to wait for halos if the logic engine doesn't say
+const defaultHaloTimeout = 5 * time.Second
 
 type Worker struct {
 	shutdownChannel chan bool
-	threadNumber int
-	strips       chan stripInfo
+	threadNumber    int
+	kernel          *gol.Kernel
+	address         string
+	engineAddr      string
+	security        gol.Security // how connections to and from the node are protected
+	left            chan bool    // closed when the node leaves, so that it stops subscribing again
+	leaveOnce       sync.Once
+
+	sessionsLock sync.Mutex
+	sessions     map[string]*sessionStrip // the strip this node owns in each of the logic engine's sessions
+}
+
+// The strip of the world owned by a node in one session, kept between turns
+type sessionStrip struct {
+	worker *Worker
+
+	stripLock  sync.Mutex
+	epoch      int
+	strip      [][]byte
+	shown      gol.PackedWorld // the strip as it was when its flipped cells were last reported
+	width      int
+	rule       gol.Rule
+	boundary   gol.Boundary
+	aboveEdge  gol.Edge
+	belowEdge  gol.Edge
+	above      string
+	below      string
+	neighbours map[string]*rpc.Client
+
+	// halo rows received from the neighbours, indexed by turn
+	haloLock  sync.Mutex
+	haloCond  *sync.Cond
+	haloEpoch int
+	fromAbove map[int][][]byte
+	fromBelow map[int][][]byte
+}
+
+// returns the node's strip for the session, creating an empty one if the session is new
+func (w *Worker) session(id string) *sessionStrip {
+	w.sessionsLock.Lock()
+	defer w.sessionsLock.Unlock()
+	s, ok := w.sessions[id]
+	if !ok {
+		s = &sessionStrip{
+			worker:     w,
+			neighbours: make(map[string]*rpc.Client),
+			fromAbove:  make(map[int][][]byte),
+			fromBelow:  make(map[int][][]byte),
+		}
+		s.haloCond = sync.NewCond(&s.haloLock)
+		w.sessions[id] = s
+	}
+	return s
+}
+
+// returns the node's strip for a session it has already been given a strip in
+func (w *Worker) lookup(id string) (*sessionStrip, error) {
+	w.sessionsLock.Lock()
+	defer w.sessionsLock.Unlock()
+	s, ok := w.sessions[id]
+	if !ok {
+		return nil, fmt.Errorf("no strip for session %q", id)
+	}
+	return s, nil
 }
 
 // called by the logic engine to shutdown the node
@@ -25,217 +93,365 @@ func (w *Worker) Shutdown(msg string, reply *string) (err error) {
 	return
 }
 
-type worldInfo struct {
-	width  int
-	height int
+// called to make the node leave the logic engine cleanly and shut down. The logic engine moves the
+// node's strips to the other nodes after their current turn before replying
+func (w *Worker) Leave(msg string, reply *bool) (err error) {
+	if err = w.leave(); err != nil {
+		return
+	}
+	fmt.Println("Left the logic engine")
+	go func() { w.shutdownChannel <- true }()
+	return
 }
 
-//Assume that it is always the full width of the world
-//Information about the strip of the world for a worker to process
-type stripInfo struct {
-	world  [][]uint8  //The world to act upon
-	out    *[][]uint8 //The world to write to
-	top    int        //The top row to start on
-	bottom int        //The first non-altered row number
-	w      worldInfo  //There is no Params here, so it needs its own lil' struct
-	wait   *sync.WaitGroup
+func (w *Worker) leave() error {
+	w.leaveOnce.Do(func() { close(w.left) })
+	client, err := w.security.Dial(w.engineAddr)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+	return client.Call("Game.Leave", w.address, nil)
 }
 
-func workerFunction(strips <-chan stripInfo) {
-	for {
-		info := <-strips
-		calculateNextStateOfStrip(&info.world, info.out, info.top, info.bottom, info.w)
-		info.wait.Done()
-	}
+// called by the logic engine to check that the node is still responding
+func (w *Worker) Ping(msg string, reply *bool) (err error) {
+	*reply = true
+	return
 }
 
+// called by the logic engine to give the node its strip of the world, along with the addresses
+// of the nodes that own the rows directly above and below it
+func (w *Worker) Init(args gol.StripArgs, reply *bool) (err error) {
+	return w.session(args.Session).init(args)
+}
 
+func (s *sessionStrip) init(args gol.StripArgs) (err error) {
+	// the neighbours are dialled again each time, as old connections may be to nodes that have since failed
+	neighbours := make(map[string]*rpc.Client)
+	for _, address := range []string{args.Above, args.Below} {
+		if address == "" || address == s.worker.address || neighbours[address] != nil {
+			continue
+		}
+		client, err := s.worker.security.Dial(address)
+		if err != nil {
+			return err
+		}
+		neighbours[address] = client
+	}
 
-// the main function of the worker called by the logic engine to process the world
-func (w *Worker) NextState(world [][]byte, out *[][]byte) (err error) {
-	boardHeight := len(world)
-	boardWidth := len(world[0])
-	*out = calculateNextState(world, boardHeight, boardWidth, w.strips, w.threadNumber)[1 : len(world)-1]
+	// throw away any halos left over from the previous distribution of the world, this also
+	// stops a Step from the previous distribution that is still waiting for halos
+	s.haloLock.Lock()
+	s.haloEpoch = args.Epoch
+	s.fromAbove = make(map[int][][]byte)
+	s.fromBelow = make(map[int][][]byte)
+	s.haloCond.Broadcast()
+	s.haloLock.Unlock()
+
+	s.stripLock.Lock()
+	defer s.stripLock.Unlock()
+	for _, client := range s.neighbours {
+		client.Close()
+	}
+	s.neighbours = neighbours
+	s.epoch = args.Epoch
+	s.strip = args.Strip.Unpack()
+	s.shown = args.Strip
+	s.width = args.Strip.Width
+	s.rule = args.Rule
+	s.boundary = args.Boundary
+	s.aboveEdge = args.AboveEdge
+	s.belowEdge = args.BelowEdge
+	s.above = args.Above
+	s.below = args.Below
 	return
 }
 
-// probably replace
-func (w *Worker) spawnWorkerThreads() () {
-	for i := 0; i < w.threadNumber; i++ {
-		go workerFunction(w.strips)
+// called by the logic engine when a session ends so that the node can forget its strip
+func (w *Worker) Drop(session string, reply *bool) (err error) {
+	w.sessionsLock.Lock()
+	s, ok := w.sessions[session]
+	delete(w.sessions, session)
+	w.sessionsLock.Unlock()
+	if ok {
+		// wakes any Step still waiting for halos and closes the connections to the neighbours
+		s.init(gol.StripArgs{Epoch: -1})
 	}
 	return
 }
 
-// //###################################################################//
-//### The following functions calculate the number of alive cells ###//
-//### neighbouring the given cell. This is much faster than using ###//
-//### a single mod function for all (2.22s -> 1.51s). While they  ###//
-//### could be generalised using higher order functions, this     ###//
-//### counteracts all performance benefits (2.12s), so we elected ###//
-//### to keep with this method.                                   ###//
-//###################################################################//
-
-func calculateNeighbours(x, y int, world [][]byte, height, width int) int {
-	neighbours := 0
-	for i := -1; i <= 1; i++ {
-		for j := -1; j <= 1; j++ {
-			if i != 0 || j != 0 {
-				if world[y+i][x+j] == alive {
-					neighbours++
-				}
-			}
-		}
+// called by the neighbouring nodes to hand over the edge rows of their strips
+func (w *Worker) Halo(h gol.Halo, reply *bool) (err error) {
+	// halos for a session the node doesn't know about are late ones for a session that has ended
+	if s, err := w.lookup(h.Session); err == nil {
+		s.halo(h)
 	}
-	return neighbours
+	return
 }
 
-func calculateNeighboursClampX(x, y int, world [][]byte, height, width int) int {
-	neighbours := 0
-	for i := -1; i <= 1; i++ {
-		for j := -1; j <= 1; j++ {
-			if i != 0 || j != 0 {
-				if world[y+i][gol.Mod(x+j, width)] == alive {
-					neighbours++
-				}
-			}
-		}
+func (s *sessionStrip) halo(h gol.Halo) {
+	s.haloLock.Lock()
+	defer s.haloLock.Unlock()
+	if h.Epoch != s.haloEpoch {
+		return
 	}
-	return neighbours
+	if h.FromAbove {
+		s.fromAbove[h.Turn] = h.Rows.Unpack()
+	} else {
+		s.fromBelow[h.Turn] = h.Rows.Unpack()
+	}
+	s.haloCond.Broadcast()
 }
 
-func calculateNeighboursClampY(x, y int, world [][]byte, height, width int) int {
-	neighbours := 0
-	for i := -1; i <= 1; i++ {
-		for j := -1; j <= 1; j++ {
-			if i != 0 || j != 0 {
-				if world[gol.Mod(y+i, height)][x+j] == alive {
-					neighbours++
-				}
-			}
-		}
+// sends the rows to the neighbour at address, skipping the network if the neighbour is this node
+func (s *sessionStrip) sendHalo(address string, h gol.Halo) *rpc.Call {
+	if address == s.worker.address {
+		s.halo(h)
+		call := &rpc.Call{Done: make(chan *rpc.Call, 1)}
+		call.Done <- call
+		return call
 	}
-	return neighbours
+	return s.neighbours[address].Go("Worker.Halo", h, nil, make(chan *rpc.Call, 1))
 }
 
-func calculateNeighboursClamp(x, y int, world [][]byte, height, width int) int {
-	neighbours := 0
-	for i := -1; i <= 1; i++ {
-		for j := -1; j <= 1; j++ {
-			if i != 0 || j != 0 {
-				if world[gol.Mod(y+i, height)][gol.Mod(x+j, width)] == alive {
-					neighbours++
-				}
-			}
+// blocks until both halos for the turn have arrived, returning an error if the world is
+// redistributed while waiting
+func (s *sessionStrip) waitForHalos(epoch, turn int, needAbove, needBelow bool, timeout time.Duration) (above, below [][]byte, err error) {
+	// wake up the wait below when the timeout runs out
+	deadline := time.Now().Add(timeout)
+	timer := time.AfterFunc(timeout, func() {
+		s.haloLock.Lock()
+		s.haloCond.Broadcast()
+		s.haloLock.Unlock()
+	})
+	defer timer.Stop()
+
+	s.haloLock.Lock()
+	defer s.haloLock.Unlock()
+	for {
+		if s.haloEpoch != epoch {
+			return nil, nil, errors.New("world was redistributed")
+		}
+		var gotAbove, gotBelow bool
+		above, gotAbove = s.fromAbove[turn]
+		below, gotBelow = s.fromBelow[turn]
+		if (gotAbove || !needAbove) && (gotBelow || !needBelow) {
+			delete(s.fromAbove, turn)
+			delete(s.fromBelow, turn)
+			return
+		}
+		if time.Now().After(deadline) {
+			return nil, nil, fmt.Errorf("timed out waiting for halos for turn %d", turn)
 		}
+		s.haloCond.Wait()
 	}
-	return neighbours
 }
 
-func setAliveDead(world [][]byte, newWorld [][]byte, x, y, neighbours int) {
-	if world[y][x] == alive {
-		if neighbours == 2 || neighbours == 3 {
-			newWorld[y][x] = alive
-		} else {
-			newWorld[y][x] = dead
-		}
-	} else {
-		if neighbours == 3 {
-			newWorld[y][x] = alive
-		} else {
-			newWorld[y][x] = dead
-		}
+// the main function of the worker called by the logic engine each turn. The top and bottom rows of
+// the strip are swapped with the neighbours before the next state of the strip is calculated
+func (w *Worker) Step(args gol.StepArgs, reply *gol.StepReply) (err error) {
+	s, err := w.lookup(args.Session)
+	if err != nil {
+		return
 	}
+	return s.step(args, reply)
 }
 
-func calculateNextStateOfStrip(world, out *[][]byte, top, bottom int, w worldInfo) {
+func (s *sessionStrip) step(args gol.StepArgs, reply *gol.StepReply) (err error) {
+	s.stripLock.Lock()
+	defer s.stripLock.Unlock()
+	if args.Epoch != s.epoch {
+		return errors.New("step for an old distribution of the world")
+	}
+	height := len(s.strip)
+	if args.HaloTimeout <= 0 {
+		args.HaloTimeout = defaultHaloTimeout
+	}
+	turns := args.Turns
+	if turns < 1 {
+		turns = 1
+	}
+	if turns > height {
+		return fmt.Errorf("can't do %d turns at once with a strip of %d rows", turns, height)
+	}
 
-	var sideFunc func(int, int, [][]byte, int, int) int
-	var midFunc func(int, int, [][]byte, int, int) int
+	// to do several turns at once the halo has to be as deep as the number of turns, as every turn
+	// the outermost rows of the halo are used up to calculate the rows inside them
+	var calls []*rpc.Call
+	if s.aboveEdge.Exchanged() {
+		calls = append(calls, s.sendHalo(s.above, gol.Halo{Session: args.Session, Epoch: args.Epoch, Turn: args.Turn, FromAbove: false, Rows: gol.PackWorld(s.strip[:turns])}))
+	}
+	if s.belowEdge.Exchanged() {
+		calls = append(calls, s.sendHalo(s.below, gol.Halo{Session: args.Session, Epoch: args.Epoch, Turn: args.Turn, FromAbove: true, Rows: gol.PackWorld(s.strip[height-turns:])}))
+	}
+	timeout := time.After(args.HaloTimeout)
+	for _, call := range calls {
+		select {
+		case call = <-call.Done:
+			if call.Error != nil {
+				return fmt.Errorf("sending halo to neighbour: %v", call.Error)
+			}
+		case <-timeout:
+			return errors.New("timed out sending halo to neighbour")
+		}
+	}
 
-	for y := top; y < bottom; y++ {
-		if y == 0 || y == w.height-1 {
-			sideFunc = calculateNeighboursClamp
-			midFunc = calculateNeighboursClampY
-		} else {
-			sideFunc = calculateNeighboursClampX
-			midFunc = calculateNeighbours
+	above, below, err := s.waitForHalos(args.Epoch, args.Turn, s.aboveEdge.Exchanged(), s.belowEdge.Exchanged(), args.HaloTimeout)
+	if err != nil {
+		return
+	}
+	above = gol.EdgeRows(s.aboveEdge, above, s.strip[:turns], s.width)
+	below = gol.EdgeRows(s.belowEdge, below, s.strip[height-turns:], s.width)
+
+	// contexted world includes the neighbours' rows above and below
+	contextedWorld := make([][]byte, 0, len(above)+height+len(below))
+	contextedWorld = append(contextedWorld, above...)
+	contextedWorld = append(contextedWorld, s.strip...)
+	contextedWorld = append(contextedWorld, below...)
+	started := time.Now()
+	for turn := 1; turn <= turns; turn++ {
+		contextedWorld = s.worker.kernel.NextState(contextedWorld, turn, len(contextedWorld)-turn, s.width, s.rule, s.boundary)
+		// rows beyond a dead edge have to stay dead rather than evolving with the rest of the halo
+		if s.aboveEdge == gol.DeadEdge {
+			gol.ClearRows(contextedWorld[:turns])
 		}
+		if s.belowEdge == gol.DeadEdge {
+			gol.ClearRows(contextedWorld[len(contextedWorld)-turns:])
+		}
+	}
+	s.strip = contextedWorld[turns : len(contextedWorld)-turns]
+	reply.ComputeTime = time.Since(started)
 
-		neighbours := sideFunc(0, y, *world, w.height, w.width)
-		setAliveDead(*world, *out, 0, y, neighbours)
-		for x := 1; x < w.width-1; x++ {
-			neighbours = midFunc(x, y, *world, w.height, w.width)
-			setAliveDead(*world, *out, x, y, neighbours)
+	for _, row := range s.strip {
+		for _, cell := range row {
+			if cell == alive {
+				reply.AliveCells++
+			}
 		}
-		neighbours = sideFunc(w.width-1, y, *world, w.height, w.width)
-		setAliveDead(*world, *out, w.width-1, y, neighbours)
 	}
+	return
 }
 
-func calculateNextState(world [][]byte, height, width int, stripChannel chan<- stripInfo, threadNumber int) [][]byte {
-	newWorld := make([][]byte, height)
-	for i := 0; i < height; i++ {
-		newWorld[i] = make([]byte, width)
+// returns the strip owned by the node to the logic engine
+func (w *Worker) GetStrip(session string, strip *gol.PackedWorld) (err error) {
+	s, err := w.lookup(session)
+	if err != nil {
+		return
 	}
+	s.stripLock.Lock()
+	defer s.stripLock.Unlock()
+	*strip = gol.PackWorld(s.strip)
+	return
+}
 
-	threadsToMake := threadNumber
-	for threadsToMake > height {
-		threadsToMake -= 1
+// returns the cells of the strip that have flipped since they were last asked for, or since the node was
+// given the strip. This is how the logic engine keeps displays up to date without gathering the world
+func (w *Worker) GetFlips(session string, flips *gol.Flips) (err error) {
+	s, err := w.lookup(session)
+	if err != nil {
+		return
 	}
-	w := worldInfo{width: width, height: height}
-	var wg sync.WaitGroup
-	wg.Add(threadsToMake)
-	currentBottom := 0
+	s.stripLock.Lock()
+	defer s.stripLock.Unlock()
+	current := gol.PackWorld(s.strip)
+	*flips = gol.Diff(s.shown, current)
+	s.shown = current
+	return
+}
 
-	//May not cleanly divide
-	for i := 0; i < threadsToMake-1; i++ {
-		nextBottom := currentBottom + (height / threadsToMake) //compiler can optimise this line
-		stripChannel <- stripInfo{world: world, out: &newWorld, top: currentBottom, bottom: nextBottom, w: w, wait: &wg}
-		currentBottom = nextBottom
+// creates a worker listening on address and starts its threads
+func newWorker(address string, threads int, shutdownChannel chan bool) *Worker {
+	worker := &Worker{
+		shutdownChannel: shutdownChannel,
+		threadNumber:    threads,
+		kernel:          gol.NewKernel(threads),
+		address:         address,
+		left:            make(chan bool),
+		sessions:        make(map[string]*sessionStrip),
 	}
-	stripChannel <- stripInfo{world: world, out: &newWorld, top: currentBottom, bottom: height, w: w, wait: &wg}
-
-	wg.Wait()
-
-	return newWorld
+	return worker
 }
 
-// connects to the logic engine and subscribes with its own address, this allows the logic engine to access the NextState function on this node
-func connectToEngine(client *rpc.Client, pAddr string, engineAddr string) {
+// connects to the logic engine and subscribes with its own address, this allows the logic engine to access the Init and Step functions on this node
+func connectToEngine(client *rpc.Client, pAddr string, threads int) {
 	var msg string
-	err := client.Call("Game.Subscribe", pAddr, &msg)
-	fmt.Println("connected to engine")
+	err := client.Call("Game.Subscribe", gol.WorkerInfo{Address: pAddr, Threads: threads}, &msg)
 	if err != nil {
-		panic(err)
+		fmt.Println("Error subscribing to engine:", err)
+		return
 	}
+	fmt.Println("connected to engine")
 	fmt.Println(msg)
 }
 
+// keeps the node subscribed to the logic engine until it leaves. The node subscribes again if the logic engine
+// has forgotten about it, which happens if the node stopped responding for a while or the logic engine restarted
+func (w *Worker) stayConnected(threads int, interval time.Duration) {
+	var client *rpc.Client
+	for ; ; time.Sleep(interval) {
+		select {
+		case <-w.left:
+			if client != nil {
+				client.Close()
+			}
+			return
+		default:
+		}
+		if client == nil {
+			var err error
+			client, err = w.security.Dial(w.engineAddr)
+			if err != nil {
+				fmt.Println("Error connecting to engine:", err)
+				client = nil
+				continue
+			}
+		}
+		var subscribed bool
+		if err := client.Call("Game.IsSubscribed", w.address, &subscribed); err != nil {
+			fmt.Println("Lost connection to engine:", err)
+			client.Close()
+			client = nil
+		} else if !subscribed {
+			connectToEngine(client, w.address, threads)
+		}
+	}
+}
+
 func main() {
 	port := flag.String("port", "8050", "Port to listen on")
 	pAddr := flag.String("ip", "127.0.0.1", "IP to listen on")
-	engineAddr := flag.String("engine", "127.0.0.1:8030", "Address of the logic engine")
+	engineAddr := flag.String("engine", "127.0.0.1:8030", "Address of the logic engine, the node waits for the logic engine to connect to it if empty")
 	threads := flag.Int("threads", 4, "number of threads to use for computation")
+	heartbeat := flag.Duration("heartbeat", 2*time.Second, "how often to check the node is still subscribed to the logic engine")
+	var security gol.Security
+	security.Flags(flag.CommandLine)
 	flag.Parse()
 
 	shutdownChannel := make(chan bool)
-	strips := make(chan stripInfo)
+	worker := newWorker(*pAddr+":"+*port, *threads, shutdownChannel)
+	worker.security = security
+	worker.engineAddr = *engineAddr
 
-	worker := Worker{shutdownChannel, *threads, strips}
-	worker.spawnWorkerThreads()
-
-	rpc.Register(&worker)
-	listener, err := net.Listen("tcp", ":"+*port)
+	rpc.Register(worker)
+	listener, err := security.Listen(":" + *port)
 	if err != nil {
 		panic(err)
 	}
-	client, err := rpc.Dial("tcp", *engineAddr)
-	defer client.Close()
-	if err != nil {
-		panic(err)
+	go security.Accept(listener, rpc.DefaultServer)
+	if *engineAddr != "" {
+		go worker.stayConnected(*threads, *heartbeat)
+	}
+
+	// leave cleanly on ctrl-c, rather than making the logic engine recompute turns after noticing the node has gone
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	select {
+	case <-shutdownChannel:
+	case <-signals:
+		fmt.Println("Leaving the logic engine")
+		if err := worker.leave(); err != nil {
+			fmt.Println("Error leaving:", err)
+		}
 	}
-	go connectToEngine(client, *pAddr+":"+*port, *engineAddr)
-	go rpc.Accept(listener)
-	<- shutdownChannel
 }
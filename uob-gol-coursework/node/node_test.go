@@ -0,0 +1,293 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/rpc"
+	"sync"
+	"testing"
+	"time"
+
+	"uk.ac.bris.cs/gameoflife/gol"
+	"uk.ac.bris.cs/gameoflife/internal/goltest"
+)
+
+// startWorkers creates n workers, each served by its own rpc server on a local port
+func startWorkers(t *testing.T, n int) []*Worker {
+	workers := make([]*Worker, n)
+	for i := range workers {
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		workers[i] = newWorker(listener.Addr().String(), 2, make(chan bool, 1))
+		server := rpc.NewServer()
+		server.RegisterName("Worker", workers[i])
+		go server.Accept(listener)
+	}
+	return workers
+}
+
+// splits the world between the workers the same way the logic engine does
+func distribute(t *testing.T, workers []*Worker, session string, world gol.PackedWorld, boundary gol.Boundary, epoch int) {
+	top := 0
+	for i, w := range workers {
+		bottom := top + world.Height/len(workers)
+		if i == len(workers)-1 {
+			bottom = world.Height
+		}
+		args := gol.StripArgs{
+			Session: session,
+			Epoch:   epoch,
+			Strip:   world.Rows(top, bottom),
+			Rule:    gol.MustParseRule(gol.Conway),
+			Above:   workers[gol.Mod(i-1, len(workers))].address,
+			Below:   workers[gol.Mod(i+1, len(workers))].address,
+
+			Boundary: boundary,
+		}
+		if i == 0 {
+			args.AboveEdge = boundary.VerticalEdge()
+		}
+		if i == len(workers)-1 {
+			args.BelowEdge = boundary.VerticalEdge()
+		}
+		if err := w.Init(args, nil); err != nil {
+			t.Fatal(err)
+		}
+		top = bottom
+	}
+}
+
+func step(t *testing.T, workers []*Worker, args gol.StepArgs) {
+	var wg sync.WaitGroup
+	wg.Add(len(workers))
+	for _, w := range workers {
+		go func(w *Worker) {
+			if err := w.Step(args, &gol.StepReply{}); err != nil {
+				t.Error(err)
+			}
+			wg.Done()
+		}(w)
+	}
+	wg.Wait()
+}
+
+func gather(workers []*Worker, session string) gol.PackedWorld {
+	strips := make([]gol.PackedWorld, len(workers))
+	for i, w := range workers {
+		w.GetStrip(session, &strips[i])
+	}
+	return gol.JoinRows(strips...)
+}
+
+// TestBatchTurns checks that calculating several turns between halo exchanges gives the same world
+// as exchanging halos every turn, including across the top and bottom edges of the world
+func TestBatchTurns(t *testing.T) {
+	const width, height, turns = 37, 24, 24
+	start := gol.PackAliveCells(goltest.RandomCells(width, height), width, height)
+
+	for _, n := range []int{1, 2, 3} {
+		workers := startWorkers(t, n)
+		distribute(t, workers, "", start, gol.Torus, 1)
+		for turn := 0; turn < turns; turn++ {
+			step(t, workers, gol.StepArgs{Epoch: 1, Turn: turn, Turns: 1})
+		}
+		expected := gather(workers, "")
+
+		for batch := 2; batch <= height/n; batch++ {
+			t.Run(fmt.Sprintf("%d-workers-%d-turns", n, batch), func(t *testing.T) {
+				distribute(t, workers, "", start, gol.Torus, batch)
+				for turn := 0; turn < turns; turn += batch {
+					k := batch
+					if turns-turn < k {
+						k = turns - turn
+					}
+					step(t, workers, gol.StepArgs{Epoch: batch, Turn: turn, Turns: k})
+				}
+				if got := gather(workers, ""); string(got.Bits) != string(expected.Bits) {
+					t.Errorf("world after %d turns differs from calculating one turn at a time", turns)
+				}
+			})
+		}
+	}
+}
+
+// TestBoundaries checks each boundary against calculating the world one cell at a time
+func TestBoundaries(t *testing.T) {
+	const width, height, turns = 21, 18, 12
+	start := gol.PackAliveCells(goltest.RandomCells(width, height), width, height)
+	rule := gol.MustParseRule(gol.Conway)
+	workers := startWorkers(t, 3)
+
+	for _, boundary := range []gol.Boundary{gol.Torus, gol.DeadEdges, gol.Reflect, gol.KleinBottle} {
+		expected := gol.PackAliveCells(goltest.After(start, width, height, turns, rule, boundary), width, height)
+
+		for n := 1; n <= len(workers); n++ {
+			for _, batch := range []int{1, 4} {
+				t.Run(fmt.Sprintf("%v-%d-workers-%d-turns", boundary, n, batch), func(t *testing.T) {
+					epoch := int(boundary)*100 + n*10 + batch
+					distribute(t, workers[:n], "", start, boundary, epoch)
+					for turn := 0; turn < turns; turn += batch {
+						step(t, workers[:n], gol.StepArgs{Epoch: epoch, Turn: turn, Turns: batch})
+					}
+					if got := gather(workers[:n], ""); string(got.Bits) != string(expected.Bits) {
+						t.Errorf("world after %d turns is wrong", turns)
+					}
+				})
+			}
+		}
+	}
+}
+
+// TestSessions checks that workers calculating strips for two sessions at once keep them apart,
+// and that a dropped session is forgotten
+func TestSessions(t *testing.T) {
+	const width, height, turns = 23, 16, 10
+	rule := gol.MustParseRule(gol.Conway)
+	workers := startWorkers(t, 2)
+	worlds := map[string]gol.PackedWorld{
+		"a": gol.PackAliveCells(goltest.RandomCells(width, height), width, height),
+		"b": gol.PackAliveCells(goltest.RandomCells(height, width), height, width),
+	}
+	boundaries := map[string]gol.Boundary{"a": gol.Torus, "b": gol.DeadEdges}
+
+	var wg sync.WaitGroup
+	for session := range worlds {
+		distribute(t, workers, session, worlds[session], boundaries[session], 1)
+		wg.Add(1)
+		go func(session string) {
+			for turn := 0; turn < turns; turn++ {
+				step(t, workers, gol.StepArgs{Session: session, Epoch: 1, Turn: turn, Turns: 1})
+			}
+			wg.Done()
+		}(session)
+	}
+	wg.Wait()
+
+	for session, world := range worlds {
+		expected := goltest.After(world, world.Width, world.Height, turns, rule, boundaries[session])
+		world = gol.PackAliveCells(expected, world.Width, world.Height)
+		if got := gather(workers, session); string(got.Bits) != string(world.Bits) {
+			t.Errorf("world of session %s after %d turns is wrong", session, turns)
+		}
+	}
+
+	workers[0].Drop("a", nil)
+	var strip gol.PackedWorld
+	if err := workers[0].GetStrip("a", &strip); err == nil {
+		t.Error("dropped session still has a strip")
+	}
+}
+
+// TestFlips checks that the flips reported by the nodes turn the world they were last reported from into
+// the world they have now, so displays can be kept up to date without gathering the world
+func TestFlips(t *testing.T) {
+	const width, height = 19, 12
+	rule := gol.MustParseRule(gol.Conway)
+	workers := startWorkers(t, 3)
+	world := gol.PackAliveCells(goltest.RandomCells(width, height), width, height)
+	distribute(t, workers, "", world, gol.Torus, 1)
+
+	// the world as a display would be showing it
+	shown := gol.PackedWorld{Width: width, Height: height, Bits: append([]byte{}, world.Bits...)}
+	for turn := 0; turn < 6; turn += 2 {
+		step(t, workers, gol.StepArgs{Epoch: 1, Turn: turn, Turns: 2})
+		world = gol.PackAliveCells(goltest.After(world, width, height, 2, rule, gol.Torus), width, height)
+
+		strips := make([]gol.Flips, len(workers))
+		for i, w := range workers {
+			if err := w.GetFlips("", &strips[i]); err != nil {
+				t.Fatal(err)
+			}
+		}
+		gol.JoinFlips(strips...).Apply(shown)
+		if string(shown.Bits) != string(world.Bits) {
+			t.Fatalf("applying the flips after turn %d doesn't give the world", turn+2)
+		}
+	}
+
+	var flips gol.Flips
+	if err := workers[0].GetFlips("", &flips); err != nil || len(flips.Runs) != 0 {
+		t.Errorf("got flips %v, %v when nothing has changed since they were last reported", flips.Runs, err)
+	}
+}
+
+// fakeEngine stands in for the logic engine, forgetting about the node whenever it is told to
+type fakeEngine struct {
+	lock       sync.Mutex
+	subscribed bool
+	subscribes int
+}
+
+func (f *fakeEngine) IsSubscribed(address string, subscribed *bool) error {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	*subscribed = f.subscribed
+	return nil
+}
+
+func (f *fakeEngine) Subscribe(info gol.WorkerInfo, reply *string) error {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	f.subscribed = true
+	f.subscribes++
+	return nil
+}
+
+// forgets the node, as the logic engine does when the node misses its heartbeat, and returns how many
+// times the node has subscribed
+func (f *fakeEngine) forget() int {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	f.subscribed = false
+	return f.subscribes
+}
+
+func (f *fakeEngine) subscriptions() int {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	return f.subscribes
+}
+
+// TestResubscribe checks that a node subscribes to the logic engine when it starts, subscribes again
+// whenever the logic engine forgets about it, and stops once it has left
+func TestResubscribe(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	engine := &fakeEngine{}
+	server := rpc.NewServer()
+	server.RegisterName("Game", engine)
+	go server.Accept(listener)
+
+	w := startWorkers(t, 1)[0]
+	w.engineAddr = listener.Addr().String()
+	stopped := make(chan bool)
+	go func() {
+		w.stayConnected(2, 10*time.Millisecond)
+		close(stopped)
+	}()
+
+	waitFor := func(subscribes int) {
+		for start := time.Now(); engine.subscriptions() < subscribes; time.Sleep(5 * time.Millisecond) {
+			if time.Since(start) > 5*time.Second {
+				t.Fatalf("node subscribed %d times, expected %d", engine.subscriptions(), subscribes)
+			}
+		}
+	}
+	waitFor(1)
+	time.Sleep(50 * time.Millisecond)
+	if n := engine.forget(); n != 1 {
+		t.Fatalf("node subscribed %d times while the logic engine knew about it", n)
+	}
+	waitFor(2)
+
+	w.leaveOnce.Do(func() { close(w.left) })
+	select {
+	case <-stopped:
+	case <-time.After(5 * time.Second):
+		t.Fatal("node kept checking its subscription after leaving")
+	}
+}
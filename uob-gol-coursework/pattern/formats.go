@@ -0,0 +1,374 @@
+package pattern
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"uk.ac.bris.cs/gameoflife/util"
+)
+
+// the longest line written in the formats that wrap their lines
+const lineLength = 70
+
+// calls handle with every line of the input, without its line ending, until handle returns false
+func eachLine(in *bufio.Reader, handle func(line string) (bool, error)) error {
+	for {
+		line, err := in.ReadString('\n')
+		if line == "" && err == io.EOF {
+			return nil
+		} else if err != nil && err != io.EOF {
+			return err
+		}
+		more, handleErr := handle(strings.TrimRight(line, "\r\n"))
+		if handleErr != nil || !more || err == io.EOF {
+			return handleErr
+		}
+	}
+}
+
+func readRLE(in *bufio.Reader) (*Pattern, error) {
+	p := &Pattern{}
+	header := false
+	x, y, run := 0, 0, 0
+	err := eachLine(in, func(line string) (bool, error) {
+		line = strings.TrimSpace(line)
+		switch {
+		case line == "":
+			return true, nil
+		case !header && strings.HasPrefix(line, "#"):
+			p.readRLEComment(line)
+			return true, nil
+		case !header:
+			header = true
+			return true, p.readRLEHeader(line)
+		}
+		for _, c := range line {
+			switch {
+			case c >= '0' && c <= '9':
+				if run > (MaxCells-int(c-'0'))/10 {
+					return false, fmt.Errorf("run in RLE is longer than %d cells", MaxCells)
+				}
+				run = run*10 + int(c-'0')
+				continue
+			case c == ' ' || c == '\t':
+				continue
+			}
+			if run == 0 {
+				run = 1
+			}
+			switch {
+			case c == '!':
+				return false, nil
+			case c == '$':
+				x, y = 0, y+run
+			case c == 'b' || c == '.':
+				x += run
+			case c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z':
+				// o, and the letters for the extra states of multi-state rules, are all alive
+				if len(p.Cells)+run > MaxCells {
+					return false, fmt.Errorf("RLE has more than %d alive cells", MaxCells)
+				}
+				for i := 0; i < run; i++ {
+					p.Cells = append(p.Cells, util.Cell{X: p.Origin.X + x + i, Y: p.Origin.Y + y})
+				}
+				x += run
+			default:
+				return false, fmt.Errorf("unexpected %q in RLE", c)
+			}
+			run = 0
+		}
+		return true, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if !header {
+		return nil, errors.New("RLE has no x = , y = header")
+	}
+	return p, nil
+}
+
+// reads one of the # lines before the header
+func (p *Pattern) readRLEComment(line string) {
+	text := strings.TrimSpace(line[min(2, len(line)):])
+	switch {
+	case strings.HasPrefix(line, "#CXRLE"):
+		for _, field := range strings.Fields(line[len("#CXRLE"):]) {
+			if strings.HasPrefix(field, "Pos=") {
+				fmt.Sscanf(field, "Pos=%d,%d", &p.Origin.X, &p.Origin.Y)
+			}
+		}
+	case strings.HasPrefix(line, "#N"):
+		p.Name = text
+	case strings.HasPrefix(line, "#r"):
+		p.Rule = text
+	case strings.HasPrefix(line, "#P"), strings.HasPrefix(line, "#R"):
+		// the top left corner, as written by XLife
+		fmt.Sscanf(text, "%d %d", &p.Origin.X, &p.Origin.Y)
+	default:
+		p.Comments = append(p.Comments, text)
+	}
+}
+
+// reads the x = m, y = n, rule = abc line
+func (p *Pattern) readRLEHeader(line string) error {
+	for _, field := range strings.Split(line, ",") {
+		parts := strings.SplitN(field, "=", 2)
+		if len(parts) != 2 {
+			return fmt.Errorf("RLE header %q should be x = m, y = n", line)
+		}
+		key, value := strings.TrimSpace(parts[0]), strings.TrimSpace(parts[1])
+		var err error
+		switch key {
+		case "x":
+			p.Width, err = strconv.Atoi(value)
+		case "y":
+			p.Height, err = strconv.Atoi(value)
+		case "rule":
+			// the size of a bounded grid can follow the rule, which worlds already have
+			p.Rule = strings.SplitN(value, ":", 2)[0]
+		}
+		if err != nil {
+			return fmt.Errorf("RLE header %q: %v", line, err)
+		}
+	}
+	if p.Width < 0 || p.Height < 0 || p.Height > 0 && p.Width > MaxCells/p.Height {
+		return fmt.Errorf("RLE header %q is outside 0 to %d cells", line, MaxCells)
+	}
+	return nil
+}
+
+func min(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+func writeRLE(out *bufio.Writer, p *Pattern) {
+	if p.Name != "" {
+		fmt.Fprintf(out, "#N %s\n", p.Name)
+	}
+	for _, comment := range p.Comments {
+		fmt.Fprintf(out, "#C %s\n", comment)
+	}
+	if p.Origin != (util.Cell{}) {
+		fmt.Fprintf(out, "#CXRLE Pos=%d,%d\n", p.Origin.X, p.Origin.Y)
+	}
+	rule := p.Rule
+	if rule == "" {
+		rule = "B3/S23"
+	}
+	fmt.Fprintf(out, "x = %d, y = %d, rule = %s\n", p.Width, p.Height, rule)
+
+	// runs are only cut where a line would be too long, never in the middle of one
+	length := 0
+	write := func(run int, tag byte) {
+		token := string(tag)
+		if run > 1 {
+			token = strconv.Itoa(run) + token
+		}
+		if length+len(token) > lineLength {
+			out.WriteByte('\n')
+			length = 0
+		}
+		out.WriteString(token)
+		length += len(token)
+	}
+	newlines := 0
+	for _, row := range p.grid() {
+		// dead cells at the end of a row are left out
+		end := len(row)
+		for end > 0 && !row[end-1] {
+			end--
+		}
+		if end > 0 && newlines > 0 {
+			write(newlines, '$')
+			newlines = 0
+		}
+		for x := 0; x < end; {
+			run := 1
+			for x+run < end && row[x+run] == row[x] {
+				run++
+			}
+			tag := byte('b')
+			if row[x] {
+				tag = 'o'
+			}
+			write(run, tag)
+			x += run
+		}
+		newlines++
+	}
+	write(1, '!')
+	out.WriteByte('\n')
+}
+
+func readLife105(in *bufio.Reader) (*Pattern, error) {
+	p := &Pattern{}
+	block := util.Cell{}
+	row := 0
+	err := eachLine(in, func(line string) (bool, error) {
+		line = strings.TrimSpace(line)
+		switch {
+		case strings.HasPrefix(line, "#D"):
+			p.Comments = append(p.Comments, strings.TrimSpace(line[2:]))
+		case strings.HasPrefix(line, "#N"):
+			p.Rule = "B3/S23"
+		case strings.HasPrefix(line, "#R"):
+			// the rule is survival/birth without letters
+			p.Rule = strings.TrimSpace(line[2:])
+		case strings.HasPrefix(line, "#P"):
+			if _, err := fmt.Sscanf(line[2:], "%d %d", &block.X, &block.Y); err != nil {
+				return false, fmt.Errorf("Life 1.05 block %q: %v", line, err)
+			}
+			row = 0
+		case strings.HasPrefix(line, "#"), line == "":
+		default:
+			for x, c := range line {
+				switch c {
+				case '*':
+					p.Cells = append(p.Cells, util.Cell{X: block.X + x, Y: block.Y + row})
+				case '.':
+				default:
+					return false, fmt.Errorf("unexpected %q in Life 1.05", c)
+				}
+			}
+			row++
+		}
+		return true, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	p.fitBox()
+	return p, nil
+}
+
+// turns a rule in B/S notation into the S/B notation Life 1.05 uses
+func survivalBirth(rule string) string {
+	parts := strings.Split(strings.ToUpper(rule), "/")
+	if len(parts) == 2 && strings.HasPrefix(parts[0], "B") && strings.HasPrefix(parts[1], "S") {
+		return parts[1][1:] + "/" + parts[0][1:]
+	}
+	return rule
+}
+
+func writeLife105(out *bufio.Writer, p *Pattern) {
+	out.WriteString("#Life 1.05\n")
+	if p.Name != "" {
+		fmt.Fprintf(out, "#D %s\n", p.Name)
+	}
+	for _, comment := range p.Comments {
+		fmt.Fprintf(out, "#D %s\n", comment)
+	}
+	if p.Rule == "" || strings.EqualFold(p.Rule, "B3/S23") {
+		out.WriteString("#N\n")
+	} else {
+		fmt.Fprintf(out, "#R %s\n", survivalBirth(p.Rule))
+	}
+	fmt.Fprintf(out, "#P %d %d\n", p.Origin.X, p.Origin.Y)
+	for _, row := range p.grid() {
+		end := len(row)
+		for end > 0 && !row[end-1] {
+			end--
+		}
+		if end == 0 {
+			out.WriteString(".\n")
+			continue
+		}
+		for _, alive := range row[:end] {
+			if alive {
+				out.WriteByte('*')
+			} else {
+				out.WriteByte('.')
+			}
+		}
+		out.WriteByte('\n')
+	}
+}
+
+func readLife106(in *bufio.Reader) (*Pattern, error) {
+	p := &Pattern{}
+	err := eachLine(in, func(line string) (bool, error) {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			return true, nil
+		}
+		var cell util.Cell
+		if _, err := fmt.Sscanf(line, "%d %d", &cell.X, &cell.Y); err != nil {
+			return false, fmt.Errorf("Life 1.06 cell %q: %v", line, err)
+		}
+		p.Cells = append(p.Cells, cell)
+		return true, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	p.fitBox()
+	return p, nil
+}
+
+func writeLife106(out *bufio.Writer, p *Pattern) {
+	out.WriteString("#Life 1.06\n")
+	for _, cell := range p.Cells {
+		fmt.Fprintf(out, "%d %d\n", cell.X, cell.Y)
+	}
+}
+
+func readPlaintext(in *bufio.Reader) (*Pattern, error) {
+	p := &Pattern{}
+	err := eachLine(in, func(line string) (bool, error) {
+		if strings.HasPrefix(line, "!") {
+			text := strings.TrimSpace(line[1:])
+			if strings.HasPrefix(text, "Name:") {
+				p.Name = strings.TrimSpace(text[len("Name:"):])
+			} else {
+				p.Comments = append(p.Comments, text)
+			}
+			return true, nil
+		}
+		line = strings.TrimRight(line, " \t")
+		for x, c := range line {
+			switch c {
+			case 'O', '*':
+				p.Cells = append(p.Cells, util.Cell{X: x, Y: p.Height})
+			case '.':
+			default:
+				return false, fmt.Errorf("unexpected %q in plaintext", c)
+			}
+		}
+		if len(line) > p.Width {
+			p.Width = len(line)
+		}
+		p.Height++
+		return true, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+func writePlaintext(out *bufio.Writer, p *Pattern) {
+	if p.Name != "" {
+		fmt.Fprintf(out, "!Name: %s\n", p.Name)
+	}
+	for _, comment := range p.Comments {
+		fmt.Fprintf(out, "!%s\n", comment)
+	}
+	for _, row := range p.grid() {
+		for _, alive := range row {
+			if alive {
+				out.WriteByte('O')
+			} else {
+				out.WriteByte('.')
+			}
+		}
+		out.WriteByte('\n')
+	}
+}
@@ -0,0 +1,236 @@
+// Package pattern reads and writes the pattern formats used by the Life community: Golly RLE, Life 1.05,
+// Life 1.06 and plaintext (.cells), and places patterns into worlds
+package pattern
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"uk.ac.bris.cs/gameoflife/util"
+)
+
+// Format is one of the pattern file formats
+type Format int
+
+const (
+	RLE       Format = iota // Golly's run length encoding, with the size and rule in a header line
+	Life105                 // blocks of . and * rows, each placed with a #P line
+	Life106                 // one alive cell per line as x y
+	Plaintext               // rows of . and O with ! comments, the .cells files on LifeWiki
+)
+
+// MaxCells is the most cells a pattern can have in its box or its runs, so a forged file can't make a
+// reader run out of memory
+const MaxCells = 1 << 26
+
+// Pattern is a set of alive cells along with the box they were drawn in
+type Pattern struct {
+	Name     string
+	Comments []string
+	Rule     string    // in B/S notation, empty if the file doesn't say
+	Origin   util.Cell // top left corner of the box, cells up or left of (0, 0) have negative coordinates
+	Width    int       // size of the box, which holds every cell
+	Height   int
+	Cells    []util.Cell // the alive cells, in the same coordinates as Origin
+}
+
+// ParseFormat turns the name of a format, or a file extension, into a Format
+func ParseFormat(name string) (Format, error) {
+	switch strings.ToLower(strings.TrimPrefix(name, ".")) {
+	case "rle":
+		return RLE, nil
+	case "life105":
+		return Life105, nil
+	case "life106", "lif", "life":
+		return Life106, nil
+	case "cells", "plaintext":
+		return Plaintext, nil
+	}
+	return RLE, fmt.Errorf("unknown pattern format %q, expected rle, life105, life106 or cells", name)
+}
+
+func (f Format) String() string {
+	switch f {
+	case RLE:
+		return "rle"
+	case Life105:
+		return "life105"
+	case Life106:
+		return "life106"
+	case Plaintext:
+		return "cells"
+	default:
+		return "Incorrect Format"
+	}
+}
+
+// Extension returns the file extension patterns in the format are saved with
+func (f Format) Extension() string {
+	switch f {
+	case Life105, Life106:
+		return ".lif"
+	case Plaintext:
+		return ".cells"
+	default:
+		return ".rle"
+	}
+}
+
+// IsPattern returns whether the file name has the extension of one of the pattern formats
+func IsPattern(filename string) bool {
+	_, err := ParseFormat(filepath.Ext(filename))
+	return err == nil
+}
+
+// Read reads a pattern in any of the formats, which is worked out from how the file starts
+func Read(r io.Reader) (*Pattern, error) {
+	in := bufio.NewReader(r)
+	start, _ := in.Peek(10)
+	switch {
+	case strings.HasPrefix(string(start), "#Life 1.05"):
+		return readLife105(in)
+	case strings.HasPrefix(string(start), "#Life 1.06"):
+		return readLife106(in)
+	case strings.HasPrefix(string(start), "!") || strings.HasPrefix(string(start), ".") || strings.HasPrefix(string(start), "O"):
+		return readPlaintext(in)
+	default:
+		return readRLE(in)
+	}
+}
+
+// ReadFile reads the pattern in the file
+func ReadFile(path string) (*Pattern, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	p, err := Read(file)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	if p.Name == "" {
+		p.Name = strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+	}
+	return p, nil
+}
+
+// Write writes the pattern in the format
+func Write(w io.Writer, p *Pattern, format Format) error {
+	out := bufio.NewWriter(w)
+	switch format {
+	case RLE:
+		writeRLE(out, p)
+	case Life105:
+		writeLife105(out, p)
+	case Life106:
+		writeLife106(out, p)
+	case Plaintext:
+		writePlaintext(out, p)
+	default:
+		return fmt.Errorf("can't write %v patterns", format)
+	}
+	return out.Flush()
+}
+
+// WriteFile writes the pattern to the file in the format
+func WriteFile(path string, p *Pattern, format Format) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := Write(file, p, format); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// fits the box around the cells, for formats that don't say how big the pattern is
+func (p *Pattern) fitBox() {
+	if len(p.Cells) == 0 {
+		p.Origin, p.Width, p.Height = util.Cell{}, 0, 0
+		return
+	}
+	min, max := p.Cells[0], p.Cells[0]
+	for _, cell := range p.Cells {
+		if cell.X < min.X {
+			min.X = cell.X
+		}
+		if cell.Y < min.Y {
+			min.Y = cell.Y
+		}
+		if cell.X > max.X {
+			max.X = cell.X
+		}
+		if cell.Y > max.Y {
+			max.Y = cell.Y
+		}
+	}
+	p.Origin = min
+	p.Width = max.X - min.X + 1
+	p.Height = max.Y - min.Y + 1
+}
+
+// the cells as rows of booleans, relative to the origin
+func (p *Pattern) grid() [][]bool {
+	grid := make([][]bool, p.Height)
+	for y := range grid {
+		grid[y] = make([]bool, p.Width)
+	}
+	for _, cell := range p.Cells {
+		x, y := cell.X-p.Origin.X, cell.Y-p.Origin.Y
+		if y >= 0 && y < p.Height && x >= 0 && x < p.Width {
+			grid[y][x] = true
+		}
+	}
+	return grid
+}
+
+// Placement says where a pattern goes in a world
+type Placement struct {
+	Offset util.Cell // where the top left corner of the pattern's box goes, or how far it is moved from the centre
+	Centre bool      // whether the middle of the pattern's box goes in the middle of the world
+}
+
+// Place puts the pattern into a world of the size, with 255 for alive cells and 0 for dead ones.
+// Cells that end up outside the world are left out
+func (p *Pattern) Place(width, height int, placement Placement) [][]byte {
+	world := make([][]byte, height)
+	for y := range world {
+		world[y] = make([]byte, width)
+	}
+	dx, dy := placement.Offset.X-p.Origin.X, placement.Offset.Y-p.Origin.Y
+	if placement.Centre {
+		dx += (width - p.Width) / 2
+		dy += (height - p.Height) / 2
+	}
+	for _, cell := range p.Cells {
+		x, y := cell.X+dx, cell.Y+dy
+		if x >= 0 && x < width && y >= 0 && y < height {
+			world[y][x] = 255
+		}
+	}
+	return world
+}
+
+// FromWorld turns a world, where any cell that isn't 0 is alive, into a pattern the size of the world
+// with its top left corner at the origin
+func FromWorld(world [][]byte, origin util.Cell) *Pattern {
+	p := &Pattern{Origin: origin, Height: len(world)}
+	if len(world) > 0 {
+		p.Width = len(world[0])
+	}
+	for y, row := range world {
+		for x, cell := range row {
+			if cell != 0 {
+				p.Cells = append(p.Cells, util.Cell{X: origin.X + x, Y: origin.Y + y})
+			}
+		}
+	}
+	return p
+}
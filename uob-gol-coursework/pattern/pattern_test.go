@@ -0,0 +1,138 @@
+package pattern
+
+import (
+	"bytes"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+
+	"uk.ac.bris.cs/gameoflife/internal/goltest"
+	"uk.ac.bris.cs/gameoflife/util"
+)
+
+func sorted(cells []util.Cell) []util.Cell {
+	cells = append([]util.Cell{}, cells...)
+	sort.Slice(cells, func(i, j int) bool {
+		return cells[i].Y < cells[j].Y || cells[i].Y == cells[j].Y && cells[i].X < cells[j].X
+	})
+	return cells
+}
+
+// TestRead reads a glider written in each format as it appears in the pattern collections
+func TestRead(t *testing.T) {
+	files := map[string]string{
+		"rle":      "#N Glider\n#O Richard K. Guy\n#C The smallest spaceship\nx = 3, y = 3, rule = B3/S23\nbob$2bo$3o!\n",
+		"life105":  "#Life 1.05\n#D Glider\n#N\n#P 0 0\n.*\n..*\n***\n",
+		"life106":  "#Life 1.06\n1 0\n2 1\n0 2\n1 2\n2 2\n",
+		"cells":    "!Name: Glider\n!\n.O.\n..O\nOOO\n",
+		"rle-runs": "x=3,y=3\nbo\n$2bo$\n3\no!",
+	}
+	for name, file := range files {
+		p, err := Read(strings.NewReader(file))
+		if err != nil {
+			t.Errorf("%s: %v", name, err)
+			continue
+		}
+		if !reflect.DeepEqual(sorted(p.Cells), goltest.Glider) {
+			t.Errorf("%s: read cells %v, expected %v", name, sorted(p.Cells), goltest.Glider)
+		}
+		if p.Width != 3 || p.Height != 3 || p.Origin != (util.Cell{}) {
+			t.Errorf("%s: read a %dx%d box at %v, expected 3x3 at (0, 0)", name, p.Width, p.Height, p.Origin)
+		}
+	}
+
+	p, _ := Read(strings.NewReader(files["rle"]))
+	if p.Name != "Glider" || p.Rule != "B3/S23" || !reflect.DeepEqual(p.Comments, []string{"Richard K. Guy", "The smallest spaceship"}) {
+		t.Errorf("read name %q, rule %q and comments %q from the RLE header", p.Name, p.Rule, p.Comments)
+	}
+
+	bad := []string{"bo$2bo$3o!", "x = 3, y = 3\nb?o!", "#Life 1.06\n1 a\n", "!Name: x\n.X.\n",
+		// sizes and runs bigger than any world, which would otherwise be allocated
+		"x = 99999999999, y = 1\no!", "x = 99999, y = 99999\no!", "x = -1, y = 1\n!",
+		"x = 1, y = 1\n999999999o!", "x = 1, y = 1\n99999999999999999999o!"}
+	for _, bad := range bad {
+		if _, err := Read(strings.NewReader(bad)); err == nil {
+			t.Errorf("read %q without an error", bad)
+		}
+	}
+}
+
+// TestWrite writes a pattern with an offset origin and gaps in every format and reads it back
+func TestWrite(t *testing.T) {
+	// 80 cells wide so RLE lines have to wrap, with empty rows and columns around the cells
+	world := make([][]byte, 6)
+	for y := range world {
+		world[y] = make([]byte, 80)
+	}
+	for x := 0; x < 80; x += 2 {
+		world[1][x+stagger(x)] = 255
+		world[4][x] = 255
+	}
+	p := FromWorld(world, util.Cell{X: -5, Y: 3})
+	p.Name = "Test"
+	p.Rule = "B36/S23"
+
+	for _, format := range []Format{RLE, Life105, Life106, Plaintext} {
+		var out bytes.Buffer
+		if err := Write(&out, p, format); err != nil {
+			t.Fatal(err)
+		}
+		for _, line := range strings.Split(out.String(), "\n") {
+			if format == RLE && len(line) > lineLength {
+				t.Errorf("RLE line %q is longer than %d", line, lineLength)
+			}
+		}
+		read, err := Read(&out)
+		if err != nil {
+			t.Fatalf("%v: %v", format, err)
+		}
+		if !reflect.DeepEqual(sorted(read.Cells), sorted(plainOrigin(p, format).Cells)) {
+			t.Errorf("%v: cells changed after writing and reading them", format)
+		}
+		if format == RLE && (read.Width != 80 || read.Height != 6 || read.Origin != p.Origin || read.Rule != p.Rule) {
+			t.Errorf("RLE read back as %dx%d at %v with rule %s", read.Width, read.Height, read.Origin, read.Rule)
+		}
+	}
+}
+
+// cells alternate between being in even and odd columns
+func stagger(x int) int {
+	return x / 2 % 2
+}
+
+// plaintext can't say where the pattern is, so it comes back at (0, 0)
+func plainOrigin(p *Pattern, format Format) *Pattern {
+	if format != Plaintext {
+		return p
+	}
+	moved := &Pattern{Width: p.Width, Height: p.Height}
+	for _, cell := range p.Cells {
+		moved.Cells = append(moved.Cells, util.Cell{X: cell.X - p.Origin.X, Y: cell.Y - p.Origin.Y})
+	}
+	return moved
+}
+
+// TestPlace checks patterns are put where the placement says, and cells outside the world are left out
+func TestPlace(t *testing.T) {
+	p := &Pattern{Origin: util.Cell{X: -1, Y: -1}, Width: 3, Height: 3, Cells: []util.Cell{{X: -1, Y: -1}, {X: 1, Y: 1}}}
+	placements := map[Placement][]util.Cell{
+		{}:                              {{X: 0, Y: 0}, {X: 2, Y: 2}},
+		{Offset: util.Cell{X: 4, Y: 1}}: {{X: 4, Y: 1}},
+		{Centre: true}:                  {{X: 1, Y: 1}, {X: 3, Y: 3}},
+		{Centre: true, Offset: util.Cell{X: -1, Y: 1}}: {{X: 0, Y: 2}, {X: 2, Y: 4}},
+	}
+	for placement, expected := range placements {
+		var cells []util.Cell
+		for y, row := range p.Place(5, 5, placement) {
+			for x, cell := range row {
+				if cell == 255 {
+					cells = append(cells, util.Cell{X: x, Y: y})
+				}
+			}
+		}
+		if !reflect.DeepEqual(cells, expected) {
+			t.Errorf("placed with %+v at %v, expected %v", placement, cells, expected)
+		}
+	}
+}
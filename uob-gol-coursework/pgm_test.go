@@ -28,7 +28,10 @@ func TestPgm(t *testing.T) {
 				t.Run(testName, func(t *testing.T) {
 					events := make(chan gol.Event)
 					gol.Run(p, events, nil)
-					for range events {
+					for event := range events {
+						if report, ok := event.(gol.ErrorReport); ok {
+							t.Error(report)
+						}
 					}
 					cellsFromImage := util.ReadAliveCells(
 						"out/"+fmt.Sprintf("%vx%vx%v.pgm", p.ImageWidth, p.ImageHeight, turns),
@@ -2,6 +2,8 @@ package sdl
 
 import (
 	"fmt"
+	"os"
+
 	"github.com/veandco/go-sdl2/sdl"
 	"uk.ac.bris.cs/gameoflife/gol"
 )
@@ -35,10 +37,12 @@ sdlLoop:
 			}
 			switch e := event.(type) {
 			case gol.CellFlipped:
-				w.SetPixel(e.Cell.X, e.Cell.Y)
+				w.FlipPixel(e.Cell.X, e.Cell.Y)
 			case gol.TurnComplete:
 				w.RenderFrame()
-				w.ClearPixels()
+			case gol.ErrorReport:
+				// problems go to stderr so they aren't lost among the turn counts
+				fmt.Fprintf(os.Stderr, "Completed Turns %-8v%v\n", e.GetCompletedTurns(), e)
 			default:
 				if len(event.String()) > 0 {
 					fmt.Printf("Completed Turns %-8v%v\n", event.GetCompletedTurns(), event)
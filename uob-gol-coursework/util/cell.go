@@ -1,9 +1,9 @@
 package util
 
 import (
-	"io/ioutil"
-	"strconv"
-	"strings"
+	"os"
+
+	"uk.ac.bris.cs/gameoflife/netpbm"
 )
 
 // Cell is used as the return type for the testing framework.
@@ -11,46 +11,34 @@ type Cell struct {
 	X, Y int
 }
 
+// ReadAliveCells reads a pbm or pgm image, returning the pixels that aren't black (or are black, for a pbm) as alive cells
 func ReadAliveCells(path string, width, height int) []Cell {
 	//data, ioError := ioutil.ReadFile("check/images/" + fmt.Sprintf("%vx%vx%v.pgm", width, height, turns))
-	data, ioError := ioutil.ReadFile(path)
+	file, ioError := os.Open(path)
 	Check(ioError)
+	defer file.Close()
 
-	fields := strings.Fields(string(data))
-
-	if fields[0] != "P5" {
-		panic("Not a pgm file")
-	}
+	image, ioError := netpbm.Decode(file)
+	Check(ioError)
 
-	imageWidth, _ := strconv.Atoi(fields[1])
-	if imageWidth != width {
+	if image.Width != width {
 		panic("Incorrect width")
 	}
 
-	imageHeight, _ := strconv.Atoi(fields[2])
-	if imageHeight != height {
+	if image.Height != height {
 		panic("Incorrect height")
 	}
 
-	maxval, _ := strconv.Atoi(fields[3])
-	if maxval != 255 {
-		panic("Incorrect maxval/bit depth")
-	}
-
-	image := []byte(fields[4])
-
 	var cells []Cell
-	for y := 0; y < height; y++ {
-		for x := 0; x < width; x++ {
-			cell := image[0]
+	for y, row := range image.Cells(1) {
+		for x, cell := range row {
 			if cell != 0 {
 				cells = append(cells, Cell{
 					X: x,
 					Y: y,
 				})
 			}
-			image = image[1:]
 		}
 	}
 	return cells
-}
\ No newline at end of file
+}